@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"flag"
 	"runtime"
+	"sync"
 	"time"
 )
 
@@ -263,30 +264,21 @@ func analyseStructureSequential(structure *Structure) {
 
 func analyseStructureAsynchronous(structure *Structure) {
 	count := make(chan bool, MAX_NUM_OF_GO_ROUTINE)
+	running := new(sync.WaitGroup)
 	for id, _ := range structure.nodeMap { //default order
 		count <- true
-		go analyseNode(structure, id, count)
+		running.Add(1)
+		go analyseNode(structure, id, count, running)
 	}
 	
-	All:
-	for {
-		select {
-		case _, ok := <-count:
-			if ok {
-				count <- true
-				time.Sleep(1)
-			} else {
-				fmt.Println("Channel closed!")
-				break
-			}
-		default:
-			fmt.Println("Parallel Analyse Finish")
-			break All
-		}
-	}
+	//block until the last spawned analysis returns
+	running.Wait()
+	fmt.Println("Parallel Analyse Finish")
 }
 
-func analyseNode(structure *Structure, id int, count chan bool) {
+func analyseNode(structure *Structure, id int, count chan bool, running *sync.WaitGroup) {
+	defer running.Done()
+	
 	//check whether current node is running by other go routine
 	select {
 	case _, ok := <- structure.nodeMap[id].lock:
@@ -331,7 +323,8 @@ func analyseNode(structure *Structure, id int, count chan bool) {
 				
 				//anslyse updated nodes
 				count <- true
-				go analyseNode(structure, beam.otherEndNode.id, count)
+				running.Add(1)
+				go analyseNode(structure, beam.otherEndNode.id, count, running)
 			}
 		}
 	} else {
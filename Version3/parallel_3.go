@@ -9,6 +9,8 @@ import (
 	"strconv"
 	"flag"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,12 +19,8 @@ import (
 const (
 	TOLERANCE = 0.1
 	TOLERANCE_CHECK = 0.2
-	BUFFER_SIZE = 20
 )
 
-var REPORT_CHANNEL = make(chan bool, 1e6)	
-var FINISH_CHANNEL = make(chan bool)
-
 type Structure struct {
 	nodeMap map[int]Node
 }
@@ -31,6 +29,8 @@ type Node struct {
 	id int
 	isFixed bool
 	beams *list.List
+	mu *sync.Mutex //guards received carry-overs of the beams
+	wake chan bool
 }
 
 func (node *Node) String() (result string) {
@@ -55,6 +55,8 @@ func newNode(id int, isFixed bool) *Node {
 	node.id = id
 	node.isFixed = isFixed
 	node.beams = list.New()	
+	node.mu = new(sync.Mutex)
+	node.wake = make(chan bool, 1)
 		
 	return node
 }
@@ -66,7 +68,8 @@ type Beam struct {
 	df float64
 	cof float64 //cof to the other end
 	moment float64
-	buffer chan float64
+	received float64 //carry-overs not yet absorbed
+	numReceived int64
 }
 
 func (beam *Beam) String() (result string) {
@@ -75,6 +78,34 @@ func (beam *Beam) String() (result string) {
 	return result
 }
 
+// send adds a carry-over to the other end and wakes the other node
+func (beam *Beam) send(carryover float64) {
+	node := beam.otherEndNode
+	node.mu.Lock()
+	beam.otherEndBeam.received += carryover
+	beam.otherEndBeam.numReceived++
+	node.mu.Unlock()
+	
+	select {
+	case node.wake <- true:
+	default:
+	}
+}
+
+// absorb adds the received carry-overs to the beam moments and returns how many there were
+func (node *Node) absorb() (count int64) {
+	node.mu.Lock()
+	for e := node.beams.Front(); e != nil; e = e.Next() {
+		beam, _ := e.Value.(*Beam)
+		beam.moment += beam.received
+		count += beam.numReceived
+		beam.received = 0
+		beam.numReceived = 0
+	}
+	node.mu.Unlock()
+	return count
+}
+
 func printStructure(structure *Structure) {
 	for _, node := range structure.nodeMap {
 		fmt.Println(node.String())
@@ -190,14 +221,12 @@ func connectNodes(structure *Structure, id1 int, df1 float64, cof1 float64, mome
 	beam1.moment = moment1
 	beam1.otherEndNode = &node2
 	beam1.otherEndBeam = beam2
-	beam1.buffer = make(chan float64, BUFFER_SIZE)
 	
 	beam2.df = df2
 	beam2.cof = cof2
 	beam2.moment = moment2
 	beam2.otherEndNode = &node1
 	beam2.otherEndBeam = beam1
-	beam2.buffer = make(chan float64, BUFFER_SIZE)
 	
 	node1.beams.PushBack(beam1)
 	node2.beams.PushBack(beam2)
@@ -260,115 +289,63 @@ func analyseStructureSequential(structure *Structure) {
 //*******************ANALYZE STRUCTURE PARALLEL****
 
 func analyseStructureSynchronous(structure *Structure) {
-	//start running
-	for id, _ := range structure.nodeMap {
-		go analyseNode(structure, id)
-	}
+	finish := make(chan bool)
 	
-	All:
-	for {
-		//time.Sleep(1 * time.Millisecond)
-		for count := 0; count < len(structure.nodeMap); count++ {
-			select {
-			case _, ok := <- REPORT_CHANNEL:
-				if ok {
-					break
-				}
-			}
-		}
-		
-		isFinish := true
-		Scan:
-		for id, _ := range structure.nodeMap { //default order
-			for e := structure.nodeMap[id].beams.Front(); e != nil; e = e.Next() {
-				beam, _ := e.Value.(*Beam)
-
-				//check pending updated value
-				select {
-				case value, ok := <-beam.buffer:
-					if ok {
-						beam.buffer <- value
-						isFinish = false
-						break Scan
-					}
-				default:
-					break
-				}
-			}
-		}
-		
-		if isFinish {
-			close(FINISH_CHANNEL)
-			fmt.Println("Parallel Analyse Finish")
-			break All
-		}
+	//every node starts with one pending unit for its first balance
+	pending := int64(len(structure.nodeMap))
+	
+	running := new(sync.WaitGroup)
+	for id, _ := range structure.nodeMap { //default order
+		running.Add(1)
+		go func(id int) {
+			defer running.Done()
+			analyseNode(structure, id, &pending, finish)
+		}(id)
 	}
+	
+	//block until the last carry-over is absorbed and every node stopped
+	running.Wait()
+	fmt.Println("Parallel Analyse Finish")
 }
 
-func analyseNode(structure *Structure, id int) {	
-	AnalyseNode:
+func analyseNode(structure *Structure, id int, pending *int64, finish chan bool) {	
+	node := structure.nodeMap[id]
+	received := int64(1)
+	
 	for {
-		//check whether analyse finish
-		select {
-			case _, ok := <- FINISH_CHANNEL:
-				if !ok {
-					break AnalyseNode
-				}
-			default:
-				break
-		}
-		
-		if !structure.nodeMap[id].isFixed {
+		if !node.isFixed {
 			//calculate amount of unbalance for non-fixed ends
 			momentSum := float64(0)
-			for e := structure.nodeMap[id].beams.Front(); e != nil; e = e.Next() {
+			for e := node.beams.Front(); e != nil; e = e.Next() {
 				beam, _ := e.Value.(*Beam)
-
-				//check pending updated value
-				select {
-				case value, ok := <-beam.buffer:
-					if ok {
-						beam.moment += value
-					} else {
-						fmt.Println("Error: Channel closed!")
-						break
-					}
-				default:
-					break
-				}
 				momentSum += beam.moment
 			}
 
 			//redistribute moment and carry over
 			if (math.Abs(momentSum) > TOLERANCE) {
-				for e := structure.nodeMap[id].beams.Front(); e != nil; e = e.Next() {
+				for e := node.beams.Front(); e != nil; e = e.Next() {
 					beam, _ := e.Value.(*Beam)				
 					increment := - momentSum * beam.df
 					beam.moment += increment
-					beam.otherEndBeam.buffer <- increment * beam.cof
-				}
-			}
-		} else {
-			//check updated value for fixed ends
-			for e := structure.nodeMap[id].beams.Front(); e != nil; e = e.Next() {
-				beam, _ := e.Value.(*Beam)
-
-				select {
-				case value, ok := <-beam.buffer:
-					if ok {
-						beam.moment += value
-					} else {
-						fmt.Println("Error: Channel closed!")
-						break
-					}
-				default:
-					break
+					atomic.AddInt64(pending, 1)
+					beam.send(increment * beam.cof)
 				}
 			}
 		}
 		
-		REPORT_CHANNEL <- true
-		time.Sleep(1 * time.Millisecond)
+		//the carry-overs just absorbed are no longer in flight
+		if received > 0 && atomic.AddInt64(pending, -received) == 0 {
+			close(finish)
+			return
+		}
+		
+		//block until a carry-over arrives or the analysis is finished
+		select {
+		case <-node.wake:
+		case <-finish:
+			return
+		}
+		received = node.absorb()
 	}
 }
 
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+//*******************ANALYZE STRUCTURE ATOMIC******
+
+// atomicAddFloat64 adds delta to the float64 stored as bits at addr
+func atomicAddFloat64(addr *uint64, delta float64) {
+	for {
+		old := atomic.LoadUint64(addr)
+		sum := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(addr, old, sum) {
+			return
+		}
+	}
+}
+
+func atomicLoadFloat64(addr *uint64) float64 {
+	return math.Float64frombits(atomic.LoadUint64(addr))
+}
+
+// analyseStructureAtomic is the asynchronous solver without mailboxes: a worker
+// adds its carry-over straight into the far end with a CAS loop.
+func analyseStructureAtomic(structure *Structure) {
+	layout := newLayout(structure)
+	exitOnDivergence(analyseLayoutAtomic(layout, 4))
+	layout.writeBack()
+	fmt.Println("Atomic Analyse Finish")
+}
+
+func analyseLayoutAtomic(layout *Layout, numWorkers int) error {
+	bits := make([]uint64, layout.numEnds())
+	for i, moment := range layout.moment {
+		bits[i] = math.Float64bits(moment)
+	}
+
+	jointSets := layout.partition(numWorkers)
+	owner := layout.owners(jointSets)
+	wake := make([]chan bool, numWorkers)
+	for w := range wake {
+		wake[w] = make(chan bool, 1)
+	}
+
+	//a joint is dirty when it may be out of balance, pending counts dirty
+	//joints plus the ones being balanced, every joint starts dirty
+	dirty := make([]int32, layout.numJoints())
+	for j := range dirty {
+		dirty[j] = 1
+	}
+	pending := int64(layout.numJoints())
+	finish := make(chan bool)
+	var once sync.Once
+	end := func() { once.Do(func() { close(finish) }) }
+
+	markDirty := func(j int) {
+		if atomic.CompareAndSwapInt32(&dirty[j], 0, 1) {
+			atomic.AddInt64(&pending, 1)
+			select {
+			case wake[owner[j]] <- true:
+			default:
+			}
+		}
+	}
+
+	stop := func() {}
+	if layout.telemetry != nil {
+		stop = layout.telemetry.sample(layout, atomicSnapshot(bits))
+	}
+
+	var monitor Monitor
+	progress := make([]Progress, numWorkers)
+	check := monitor.watch(layout, progress, atomicSnapshot(bits), end)
+
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for {
+				balanced := int64(0)
+				distributed, messages := int64(0), int64(0)
+				largest := float64(0)
+				for _, j := range jointSets[w] {
+					//clear the flag before reading so a later carry-over marks j again
+					if atomic.CompareAndSwapInt32(&dirty[j], 1, 0) {
+						unbalance, sent, ok := analyseJointAtomic(layout, bits, j, markDirty)
+						if ok {
+							distributed++
+							messages += sent
+						}
+						largest = math.Max(largest, math.Abs(unbalance))
+						balanced++
+					}
+				}
+				progress[w].add(distributed, largest)
+				if layout.telemetry != nil {
+					layout.telemetry.count(distributed, messages)
+				}
+				if balanced > 0 && atomic.AddInt64(&pending, -balanced) == 0 {
+					end()
+					return
+				}
+
+				//block until one of the joints is marked or the analysis is finished
+				select {
+				case <-wake[w]:
+				case <-finish:
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	stop()
+	if err := check(); err != nil {
+		return err
+	}
+
+	for i := range bits {
+		layout.moment[i] = math.Float64frombits(bits[i])
+	}
+	return nil
+}
+
+// analyseJointAtomic distributes the unbalance of joint j and marks the far
+// joints that received a carry-over, it reports the unbalance of j, whether
+// it was out of balance and how many carry-overs it sent
+func analyseJointAtomic(layout *Layout, bits []uint64, j int, markDirty func(int)) (momentSum float64, sent int64, ok bool) {
+	if layout.isLocked[j] {
+		return 0, 0, false
+	}
+
+	//calculate amount of unbalance
+	for i := layout.offsets[j]; i < layout.offsets[j+1]; i++ {
+		momentSum += atomicLoadFloat64(&bits[i])
+	}
+
+	//redistribute moment and carry over
+	if math.Abs(momentSum) > TOLERANCE {
+		for i := layout.offsets[j]; i < layout.offsets[j+1]; i++ {
+			increment := -momentSum * layout.df[i]
+			atomicAddFloat64(&bits[i], increment)
+			if layout.cof[i] != 0 {
+				atomicAddFloat64(&bits[layout.farEnd[i]], increment*layout.cof[i])
+				markDirty(layout.farJoint[i])
+				sent++
+			}
+		}
+		return momentSum, sent, true
+	}
+	return momentSum, 0, false
+}
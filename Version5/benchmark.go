@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+//*******************BENCHMARK**********************
+
+// benchmarkLayout times the nodeMap sweep against the flat Layout sweep on the
+// same input, run with -bench <rounds> -f <file>
+func benchmarkLayout(filename string, rounds int) {
+	var mapTime, buildTime, layoutTime time.Duration
+
+	for round := 0; round < rounds; round++ {
+		structure := loadStructure(filename)
+		start := time.Now()
+		analyseMapSequential(structure)
+		mapTime += time.Since(start)
+
+		structure = loadStructure(filename)
+		start = time.Now()
+		layout := newLayout(structure)
+		buildTime += time.Since(start)
+
+		start = time.Now()
+		_, err := analyseLayoutSequential(layout, nil)
+		exitOnDivergence(err)
+		layoutTime += time.Since(start)
+	}
+
+	n := time.Duration(rounds)
+	fmt.Printf("nodeMap sweep:       %s per solve\n", mapTime/n)
+	fmt.Printf("layout build:        %s per solve\n", buildTime/n)
+	fmt.Printf("layout sweep:        %s per solve\n", layoutTime/n)
+	fmt.Printf("speedup (incl. build): %.2fx\n", float64(mapTime)/float64(buildTime+layoutTime))
+}
+
+// benchmarkParallel times the channel mailbox solver against the atomic
+// accumulation and work stealing solvers, run with -benchpar <rounds> -f <file>
+func benchmarkParallel(filename string, rounds int) {
+	var channelTime, atomicTime, stealingTime, domainTime time.Duration
+
+	for round := 0; round < rounds; round++ {
+		structure := loadStructure(filename)
+		start := time.Now()
+		analyseStructureAsynchronous(structure)
+		channelTime += time.Since(start)
+
+		structure = loadStructure(filename)
+		start = time.Now()
+		analyseStructureAtomic(structure)
+		atomicTime += time.Since(start)
+
+		structure = loadStructure(filename)
+		start = time.Now()
+		analyseStructureStealing(structure)
+		stealingTime += time.Since(start)
+
+		structure = loadStructure(filename)
+		start = time.Now()
+		analyseStructureDomains(structure)
+		domainTime += time.Since(start)
+	}
+
+	n := time.Duration(rounds)
+	fmt.Printf("channel solver:      %s per solve\n", channelTime/n)
+	fmt.Printf("atomic solver:       %s per solve\n", atomicTime/n)
+	fmt.Printf("stealing solver:     %s per solve\n", stealingTime/n)
+	fmt.Printf("domain solver:       %s per solve\n", domainTime/n)
+}
+
+// analyseMapSequential is the original nodeMap solver, kept as the benchmark reference
+func analyseMapSequential(structure *Structure) (iteration int) {
+	isFinish := false
+	for !isFinish {
+		iteration++
+		isFinish = true
+		for _, node := range structure.nodeMap { //default order
+			if node.isLocked() {
+				continue
+			}
+
+			//calculate amount of unbalance
+			momentSum := float64(0)
+			for _, end := range node.ends {
+				momentSum += end.moment
+			}
+
+			//redistribute moment and carry over
+			if math.Abs(momentSum) > TOLERANCE {
+				isFinish = false
+
+				for _, end := range node.ends {
+					increment := -momentSum * end.df
+					end.moment += increment
+					structure.nodeMap[end.otherEndNodeID].ends[end.otherEndIndex].moment += increment * end.cof
+				}
+			}
+		}
+	}
+	return iteration
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//*******************CHECKPOINTS*******************
+
+// Checkpoint is the state of a solve between rounds: the end moments of the
+// layout, the carry-overs posted but not yet taken from a mailbox and the
+// iteration counter (sweeps, or worker rounds for the channel solver)
+type Checkpoint struct {
+	Solver    string             `json:"solver"`
+	Iteration int64              `json:"iteration"`
+	NodeIDs   []int              `json:"nodeIDs"`
+	Moments   []float64          `json:"moments"`
+	Pending   []PendingCarryover `json:"pending"`
+}
+
+// PendingCarryover is an Update in a mailbox, end is a flat layout index
+type PendingCarryover struct {
+	End       int     `json:"end"`
+	Joint     int     `json:"joint"`
+	Carryover float64 `json:"carryover"`
+}
+
+// Checkpointer saves a checkpoint every interval and when the run is
+// interrupted, and exits after an interrupt
+type Checkpointer struct {
+	filename  string
+	every     time.Duration
+	solver    string
+	iteration int64 //of the checkpoint the run resumed from
+	last      time.Time
+	interrupt chan os.Signal
+}
+
+func newCheckpointer(filename string, every time.Duration, solver string, iteration int64) *Checkpointer {
+	checkpointer := &Checkpointer{filename, every, solver, iteration, time.Now(), make(chan os.Signal, 1)}
+	signal.Notify(checkpointer.interrupt, os.Interrupt)
+	return checkpointer
+}
+
+func (checkpointer *Checkpointer) stop() {
+	signal.Stop(checkpointer.interrupt)
+}
+
+// snapshot copies the state, the caller keeps the workers out
+func (checkpointer *Checkpointer) snapshot(layout *Layout, iteration int64, mailboxes []Mailbox) *Checkpoint {
+	checkpoint := &Checkpoint{Solver: checkpointer.solver, Iteration: checkpointer.iteration + iteration,
+		NodeIDs: layout.nodeIDs, Moments: append([]float64(nil), layout.moment...), Pending: []PendingCarryover{}}
+	for w := range mailboxes {
+		mailbox := &mailboxes[w]
+		mailbox.mu.Lock()
+		for _, update := range mailbox.updates {
+			checkpoint.Pending = append(checkpoint.Pending, PendingCarryover{update.endIndex, update.joint, update.carryover})
+		}
+		mailbox.mu.Unlock()
+	}
+	return checkpoint
+}
+
+// save writes the checkpoint next to the file and renames it over, so that a
+// crash while writing keeps the last one
+func (checkpointer *Checkpointer) save(checkpoint *Checkpoint) {
+	checkpointer.last = time.Now()
+	err := writeOutput(checkpointer.filename+".tmp", func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(checkpoint)
+	})
+	if err == nil {
+		err = os.Rename(checkpointer.filename+".tmp", checkpointer.filename)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Checkpoint failed:", err)
+		return
+	}
+	fmt.Fprintln(os.Stderr, "Checkpoint at iteration", checkpoint.Iteration)
+}
+
+// between is called by the sequential solver between sweeps
+func (checkpointer *Checkpointer) between(layout *Layout, iteration int64) {
+	select {
+	case <-checkpointer.interrupt:
+		checkpointer.save(checkpointer.snapshot(layout, iteration, nil))
+		os.Exit(130)
+	default:
+	}
+	if time.Since(checkpointer.last) >= checkpointer.every {
+		checkpointer.save(checkpointer.snapshot(layout, iteration, nil))
+	}
+}
+
+// watch saves checkpoints of the channel solver until finish is closed
+func (checkpointer *Checkpointer) watch(layout *Layout, mailboxes []Mailbox, gate *sync.RWMutex, rounds *int64, finish chan bool) {
+	ticker := time.NewTicker(checkpointer.every)
+	defer ticker.Stop()
+	take := func() *Checkpoint {
+		gate.Lock()
+		defer gate.Unlock()
+		return checkpointer.snapshot(layout, atomic.LoadInt64(rounds), mailboxes)
+	}
+	for {
+		select {
+		case <-finish:
+			return
+		case <-ticker.C:
+			checkpointer.save(take())
+		case <-checkpointer.interrupt:
+			checkpointer.save(take())
+			os.Exit(130)
+		}
+	}
+}
+
+// resumeCheckpoint loads a checkpoint into the end moments of the structure,
+// pending carry-overs are added to their ends. Any solver can go on from
+// there as every joint is checked in its first sweep.
+func resumeCheckpoint(structure *Structure, filename string) (*Checkpoint, error) {
+	checkpointFile, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer checkpointFile.Close()
+	checkpoint := new(Checkpoint)
+	if err := json.NewDecoder(checkpointFile).Decode(checkpoint); err != nil {
+		return nil, fmt.Errorf("checkpoint %s: %v", filename, err)
+	}
+
+	layout := newLayout(structure)
+	isSame := len(checkpoint.NodeIDs) == layout.numJoints() && len(checkpoint.Moments) == layout.numEnds()
+	for j := 0; isSame && j < layout.numJoints(); j++ {
+		isSame = checkpoint.NodeIDs[j] == layout.nodeIDs[j]
+	}
+	if !isSame {
+		return nil, fmt.Errorf("checkpoint %s is of another structure", filename)
+	}
+	copy(layout.moment, checkpoint.Moments)
+	for _, pending := range checkpoint.Pending {
+		if pending.End < 0 || pending.End >= layout.numEnds() {
+			return nil, fmt.Errorf("checkpoint %s: no end %d", filename, pending.End)
+		}
+		layout.moment[pending.End] += pending.Carryover
+	}
+	layout.writeBack()
+	return checkpoint, nil
+}
+
+// analyseStructureCheckpointed solves with the sequential or channel solver,
+// resuming from a checkpoint when resume is given and saving one every
+// interval when filename is given
+func analyseStructureCheckpointed(structure *Structure, solver, filename string, every time.Duration, resume string) error {
+	iteration := int64(0)
+	if resume != "" {
+		checkpoint, err := resumeCheckpoint(structure, resume)
+		if err != nil {
+			return err
+		}
+		iteration = checkpoint.Iteration
+		fmt.Fprintln(os.Stderr, "Resumed at iteration", iteration)
+	}
+
+	var checkpointer *Checkpointer
+	if filename != "" {
+		if every <= 0 {
+			return fmt.Errorf("checkpoint interval %s must be positive", every)
+		}
+		checkpointer = newCheckpointer(filename, every, solver, iteration)
+		defer checkpointer.stop()
+	}
+
+	layout := newLayout(structure)
+	var err error
+	switch solver {
+	case "sequential":
+		var sweeps int
+		sweeps, err = analyseLayoutSequential(layout, checkpointer)
+		iteration += int64(sweeps)
+	case "channel":
+		var rounds int64
+		rounds, err = analyseLayoutAsynchronous(layout, checkpointer)
+		iteration += rounds
+	default:
+		return fmt.Errorf("checkpoints need the sequential or channel solver, not %s", solver)
+	}
+	if err != nil {
+		return err
+	}
+	layout.writeBack()
+	fmt.Fprintln(os.Stderr, "Analyse Finish, Iteration: ", iteration)
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+//*******************COMBINATIONS******************
+
+// Combination is a factored sum of load cases, like 1.2 dead + 1.6 live
+type Combination struct {
+	name    string
+	factors []float64
+	cases   []string
+}
+
+// readLoad reads LOAD <member> <moment1> <moment2> [case], fixed end moments
+// added to a case, the base case when none is named
+func readLoad(structure *Structure, fields []string) error {
+	member, values, loadCase, err := readMemberValues(structure, fields, 2, BASE_CASE)
+	if err != nil {
+		return err
+	}
+	loadCase.moments[member.id][0] += values[0]
+	loadCase.moments[member.id][1] += values[1]
+	return nil
+}
+
+// readCombination reads COMBINATION <name> <factor> <case> [<factor> <case>]...
+func readCombination(structure *Structure, fields []string) error {
+	if len(fields) < 4 || len(fields)%2 != 0 {
+		return fmt.Errorf("COMBINATION needs a name and factor case pairs: %v", fields)
+	}
+	combination := &Combination{name: fields[1]}
+	for k := 2; k < len(fields); k += 2 {
+		factor, err := strconv.ParseFloat(fields[k], 64)
+		if err != nil {
+			return fmt.Errorf("COMBINATION %s: %v", combination.name, err)
+		}
+		combination.factors = append(combination.factors, factor)
+		combination.cases = append(combination.cases, fields[k+1])
+	}
+	structure.combinations = append(structure.combinations, combination)
+	return nil
+}
+
+// checkCombinations makes sure every combination names known cases, the cases
+// may be defined after the combination
+func checkCombinations(structure *Structure) error {
+	known := make(map[string]bool)
+	for _, loadCase := range structure.loadCases {
+		known[loadCase.name] = true
+	}
+	for _, combination := range structure.combinations {
+		for _, name := range combination.cases {
+			if !known[name] {
+				return fmt.Errorf("COMBINATION %s: no load case %q", combination.name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// combine superposes the solved cases with the factors of the combination
+func (combination *Combination) combine(results []CaseResult) CaseResult {
+	moments := make([][2]float64, len(results[0].moments))
+	for k, name := range combination.cases {
+		for _, result := range results {
+			if result.name != name {
+				continue
+			}
+			for id, m := range result.moments {
+				moments[id][0] += combination.factors[k] * m[0]
+				moments[id][1] += combination.factors[k] * m[1]
+			}
+		}
+	}
+	return CaseResult{combination.name, moments}
+}
+
+// Envelope is the largest and smallest moment of every member end over a set
+// of results and the result that gives it
+type Envelope struct {
+	max, min         [][2]float64
+	maxName, minName [][2]string
+}
+
+func newEnvelope(results []CaseResult) *Envelope {
+	envelope := new(Envelope)
+	numMembers := len(results[0].moments)
+	envelope.max = make([][2]float64, numMembers)
+	envelope.min = make([][2]float64, numMembers)
+	envelope.maxName = make([][2]string, numMembers)
+	envelope.minName = make([][2]string, numMembers)
+	for k, result := range results {
+		for id, m := range result.moments {
+			for side := 0; side < 2; side++ {
+				if k == 0 || m[side] > envelope.max[id][side] {
+					envelope.max[id][side] = m[side]
+					envelope.maxName[id][side] = result.name
+				}
+				if k == 0 || m[side] < envelope.min[id][side] {
+					envelope.min[id][side] = m[side]
+					envelope.minName[id][side] = result.name
+				}
+			}
+		}
+	}
+	return envelope
+}
+
+// writeCombinations lists every combination and the envelope over them, or
+// over the cases when there are no combinations
+func writeCombinations(w io.Writer, structure *Structure, results []CaseResult) error {
+	if err := writeLoadCases(w, structure, results); err != nil {
+		return err
+	}
+	if len(structure.combinations) == 0 && len(results) == 1 {
+		return nil
+	}
+
+	combined := make([]CaseResult, 0, len(structure.combinations))
+	for _, combination := range structure.combinations {
+		combined = append(combined, combination.combine(results))
+	}
+	for _, result := range combined {
+		fmt.Fprintf(w, "combination %s\n", result.name)
+		writeMemberMoments(w, structure, result.moments)
+	}
+	if len(combined) == 0 {
+		combined = results
+	}
+
+	fmt.Fprintln(w, "envelope")
+	envelope := newEnvelope(combined)
+	for _, member := range structure.members() {
+		for side := 0; side < 2; side++ {
+			fmt.Fprintf(w, "member %d (%d-%d) end %d: max %10.2f (%s) min %10.2f (%s)\n",
+				member.id, member.node1, member.node2, side+1,
+				envelope.max[member.id][side], envelope.maxName[member.id][side],
+				envelope.min[member.id][side], envelope.minName[member.id][side])
+		}
+	}
+	return nil
+}
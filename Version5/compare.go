@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+//******************CHECK CORRECTNESS****************
+
+// EndKey identifies an end by its member, so two runs pair up the same ends
+// whatever order their maps were filled in
+type EndKey struct {
+	member int
+	side   int
+}
+
+// EndDifference is one end whose moments differ between two structures
+type EndDifference struct {
+	Member   int     `json:"member"`
+	Side     int     `json:"side"`
+	NodeID   int     `json:"node"`
+	Moment1  float64 `json:"moment1"`
+	Moment2  float64 `json:"moment2"`
+	AbsError float64 `json:"absError"`
+	RelError float64 `json:"relError"`
+	Missing  bool    `json:"missing,omitempty"`
+}
+
+// Comparison is the full report of compareStructures
+type Comparison struct {
+	AbsTolerance float64         `json:"absTolerance"`
+	RelTolerance float64         `json:"relTolerance"`
+	NumEnds      int             `json:"numEnds"`
+	MaxError     float64         `json:"maxError"`
+	MeanError    float64         `json:"meanError"`
+	RMSError     float64         `json:"rmsError"`
+	WorstMember  int             `json:"worstMember"`
+	Differences  []EndDifference `json:"differences"`
+	Same         bool            `json:"same"`
+}
+
+type endRecord struct {
+	nodeID int
+	moment float64
+}
+
+func endsByKey(structure *Structure) map[EndKey]endRecord {
+	ends := make(map[EndKey]endRecord)
+	for id, node := range structure.nodeMap {
+		for _, end := range node.ends {
+			if end.member >= 0 {
+				ends[EndKey{end.member, end.side}] = endRecord{id, end.moment}
+			}
+		}
+	}
+	return ends
+}
+
+// compareStructures reports every end whose moments differ by more than
+// absTolerance + relTolerance * max(|moment1|, |moment2|)
+func compareStructures(structure1, structure2 *Structure, absTolerance, relTolerance float64) *Comparison {
+	comparison := new(Comparison)
+	comparison.AbsTolerance = absTolerance
+	comparison.RelTolerance = relTolerance
+	comparison.WorstMember = -1
+	comparison.Differences = []EndDifference{}
+
+	ends1 := endsByKey(structure1)
+	ends2 := endsByKey(structure2)
+
+	keys := make([]EndKey, 0, len(ends1))
+	for key := range ends1 {
+		keys = append(keys, key)
+	}
+	for key := range ends2 {
+		if _, ok := ends1[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		if keys[a].member != keys[b].member {
+			return keys[a].member < keys[b].member
+		}
+		return keys[a].side < keys[b].side
+	})
+
+	sumError, sumSquare := float64(0), float64(0)
+	for _, key := range keys {
+		end1, ok1 := ends1[key]
+		end2, ok2 := ends2[key]
+		if !ok1 || !ok2 {
+			diff := EndDifference{Member: key.member, Side: key.side, Missing: true}
+			if ok1 {
+				diff.NodeID, diff.Moment1 = end1.nodeID, end1.moment
+			} else {
+				diff.NodeID, diff.Moment2 = end2.nodeID, end2.moment
+			}
+			comparison.Differences = append(comparison.Differences, diff)
+			continue
+		}
+
+		comparison.NumEnds++
+		absError := math.Abs(end1.moment - end2.moment)
+		scale := math.Max(math.Abs(end1.moment), math.Abs(end2.moment))
+		relError := float64(0)
+		if scale > 0 {
+			relError = absError / scale
+		}
+
+		sumError += absError
+		sumSquare += absError * absError
+		if absError > comparison.MaxError || comparison.WorstMember < 0 {
+			comparison.MaxError = absError
+			comparison.WorstMember = key.member
+		}
+
+		if absError > absTolerance+relTolerance*scale {
+			comparison.Differences = append(comparison.Differences, EndDifference{
+				key.member, key.side, end1.nodeID, end1.moment, end2.moment, absError, relError, false})
+		}
+	}
+
+	if comparison.NumEnds > 0 {
+		comparison.MeanError = sumError / float64(comparison.NumEnds)
+		comparison.RMSError = math.Sqrt(sumSquare / float64(comparison.NumEnds))
+	}
+	comparison.Same = len(comparison.Differences) == 0
+	return comparison
+}
+
+// write prints the comparison as "text" or "json"
+func (comparison *Comparison) write(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(comparison)
+	case "text":
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	for _, diff := range comparison.Differences {
+		if diff.Missing {
+			fmt.Fprintf(w, "member %d end %d (node %d): only in one structure\n", diff.Member, diff.Side, diff.NodeID)
+			continue
+		}
+		fmt.Fprintf(w, "member %d end %d (node %d): %.3f vs %.3f, error %.3f (%.2f%%)\n",
+			diff.Member, diff.Side, diff.NodeID, diff.Moment1, diff.Moment2, diff.AbsError, diff.RelError*100)
+	}
+	fmt.Fprintf(w, "ends: %d, differing: %d, max error: %.4f (member %d), mean error: %.4f, RMS error: %.4f\n",
+		comparison.NumEnds, len(comparison.Differences), comparison.MaxError, comparison.WorstMember,
+		comparison.MeanError, comparison.RMSError)
+	if comparison.Same {
+		fmt.Fprintln(w, "Same")
+	} else {
+		fmt.Fprintln(w, "Not Same")
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+//*******************COMPONENTS********************
+
+// ComponentResult is the solve of one connected part of a structure, parts
+// with no support are solved too and reported as such
+type ComponentResult struct {
+	nodeIDs     []int
+	memberIDs   []int
+	isSupported bool
+	elapsed     time.Duration
+	err         error
+}
+
+// solveComponents solves every connected component as a job of its own,
+// numJobs at a time, each with the named solver. A component that does not
+// converge keeps its starting moments and does not stop the others.
+func solveComponents(structure *Structure, solver string, numJobs int) []*ComponentResult {
+	groups := components(structure)
+	results := make([]*ComponentResult, len(groups))
+	component := make(map[int]int, len(structure.nodeMap))
+	for k, group := range groups {
+		results[k] = &ComponentResult{nodeIDs: group}
+		for _, id := range group {
+			component[id] = k
+			results[k].isSupported = results[k].isSupported || structure.nodeMap[id].isSupported()
+		}
+	}
+	for _, member := range structure.members() {
+		if !member.removed {
+			result := results[component[member.node1]]
+			result.memberIDs = append(result.memberIDs, member.id)
+		}
+	}
+
+	//the largest first so that none is left to run alone at the end
+	order := make([]int, len(results))
+	for k := range order {
+		order[k] = k
+	}
+	sort.SliceStable(order, func(a, b int) bool { return len(groups[order[a]]) > len(groups[order[b]]) })
+
+	jobs := make(chan *ComponentResult)
+	var wg sync.WaitGroup
+	for w := 0; w < numJobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for result := range jobs {
+				result.solve(structure, solver)
+			}
+		}()
+	}
+	for _, k := range order {
+		jobs <- results[k]
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// solve runs the solver on the layout of the component alone, components share
+// no ends so they write back without locks
+func (result *ComponentResult) solve(structure *Structure, solver string) {
+	start := time.Now()
+	layout := newLayoutOf(structure, result.nodeIDs)
+	result.err = analyseLayoutWith(layout, solver)
+	if result.err == nil {
+		layout.writeBack()
+	}
+	result.elapsed = time.Since(start)
+}
+
+// writeComponents reports each component with the moments of its members
+func writeComponents(w io.Writer, structure *Structure, results []*ComponentResult) error {
+	moments := structure.endMoments()
+	for k, result := range results {
+		support := "supported"
+		if !result.isSupported {
+			support = "no support"
+		}
+		status := fmt.Sprintf("solved in %s", result.elapsed)
+		if result.err != nil {
+			status = fmt.Sprintf("failed: %v", result.err)
+		}
+		fmt.Fprintf(w, "component %d: %d nodes, %d members, %s, %s\n",
+			k+1, len(result.nodeIDs), len(result.memberIDs), support, status)
+		for _, id := range result.memberIDs {
+			member := structure.memberList[id]
+			fmt.Fprintf(w, "\tmember %d (%d-%d): %10.2f %10.2f\n",
+				member.id, member.node1, member.node2, moments[id][0], moments[id][1])
+		}
+	}
+	return nil
+}
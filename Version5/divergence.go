@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//*******************DIVERGENCE********************
+
+// A joint whose distribution factors sum to more than 1, or a member with a
+// large carry-over factor, makes the unbalance grow or swing for ever instead
+// of dying out. The solvers check the largest unbalance after every sweep and
+// stop when it is not finite, grows past GROWTH_LIMIT times where it started
+// or has not reached a new low for STALL_LIMIT checks. The parallel solvers
+// have no sweeps, their workers report the joints they balance and the
+// largest unbalance they meet, and every CHECK_INTERVAL the reports are
+// checked once the workers have balanced as many joints as the layout has,
+// so that a slow machine does not make a stall of a healthy solve.
+const (
+	GROWTH_LIMIT      = 1e6
+	STALL_LIMIT       = 500
+	CHECK_INTERVAL    = 10 * time.Millisecond
+	DIVERGENCE_JOINTS = 5 //joints named by a DivergenceError
+)
+
+// Monitor follows the largest unbalance of one solve
+type Monitor struct {
+	start     float64
+	best      float64
+	stalled   int
+	isStarted bool
+}
+
+// check says what is wrong with the largest unbalance, "" when nothing
+func (monitor *Monitor) check(largest float64) string {
+	switch {
+	case math.IsNaN(largest) || math.IsInf(largest, 0):
+		return "moments are not finite"
+	case !monitor.isStarted:
+		monitor.start, monitor.best, monitor.isStarted = math.Max(largest, TOLERANCE), largest, true
+	case largest > GROWTH_LIMIT*monitor.start:
+		return fmt.Sprintf("unbalance grew from %g to %g", monitor.start, largest)
+	case largest < monitor.best:
+		monitor.best, monitor.stalled = largest, 0
+	default:
+		monitor.stalled++
+		if monitor.stalled >= STALL_LIMIT {
+			return fmt.Sprintf("unbalance stalled at %g for %d checks", monitor.best, STALL_LIMIT)
+		}
+	}
+	return ""
+}
+
+// JointUnbalance is a joint named by a DivergenceError
+type JointUnbalance struct {
+	id        int
+	unbalance float64
+	dfSum     float64
+}
+
+// DivergenceError stops a solve, it names the joints with the largest
+// unbalance and their distribution factor sums
+type DivergenceError struct {
+	when   string
+	reason string
+	joints []JointUnbalance
+}
+
+func (err *DivergenceError) Error() string {
+	joints := make([]string, len(err.joints))
+	for k, joint := range err.joints {
+		joints[k] = fmt.Sprintf("%d (unbalance %g, df sum %.3f)", joint.id, joint.unbalance, joint.dfSum)
+	}
+	return fmt.Sprintf("no convergence at %s, %s at joints %s", err.when, err.reason, strings.Join(joints, ", "))
+}
+
+func jointUnbalance(layout *Layout, moment []float64, j int) (momentSum float64) {
+	for i := layout.offsets[j]; i < layout.offsets[j+1]; i++ {
+		momentSum += moment[i]
+	}
+	return momentSum
+}
+
+// largestUnbalance is NaN when any joint is
+func largestUnbalance(layout *Layout, moment []float64) (largest float64) {
+	for j := 0; j < layout.numJoints(); j++ {
+		if !layout.isLocked[j] {
+			largest = math.Max(largest, math.Abs(jointUnbalance(layout, moment, j)))
+		}
+	}
+	return largest
+}
+
+func divergenceError(layout *Layout, moment []float64, when, reason string) *DivergenceError {
+	var joints []JointUnbalance
+	for j := 0; j < layout.numJoints(); j++ {
+		if layout.isLocked[j] {
+			continue
+		}
+		joint := JointUnbalance{id: layout.nodeIDs[j], unbalance: jointUnbalance(layout, moment, j)}
+		if !(math.Abs(joint.unbalance) <= TOLERANCE) {
+			for i := layout.offsets[j]; i < layout.offsets[j+1]; i++ {
+				joint.dfSum += layout.df[i]
+			}
+			joints = append(joints, joint)
+		}
+	}
+
+	//not finite first, then the largest
+	size := func(k int) float64 {
+		if math.IsNaN(joints[k].unbalance) {
+			return math.Inf(1)
+		}
+		return math.Abs(joints[k].unbalance)
+	}
+	sort.SliceStable(joints, func(a, b int) bool { return size(a) > size(b) })
+	if len(joints) > DIVERGENCE_JOINTS {
+		joints = joints[:DIVERGENCE_JOINTS]
+	}
+	return &DivergenceError{when, reason, joints}
+}
+
+// Progress is what one worker of a parallel solve reports to the divergence
+// check: the joints it balanced and the largest unbalance it met since the
+// last check, as bits, which order non-negative floats and NaN last
+type Progress struct {
+	balanced int64
+	largest  uint64
+	_        [48]byte //one cache line per worker
+}
+
+func (progress *Progress) add(balanced int64, unbalance float64) {
+	if balanced > 0 {
+		atomic.AddInt64(&progress.balanced, balanced)
+	}
+	bits := math.Float64bits(math.Abs(unbalance))
+	for {
+		old := atomic.LoadUint64(&progress.largest)
+		if bits <= old || atomic.CompareAndSwapUint64(&progress.largest, old, bits) {
+			return
+		}
+	}
+}
+
+// watch checks the reports of the workers of a parallel solve and calls abort
+// when it diverges, snapshot is only taken for the error. The returned stop
+// ends it and checks the result.
+func (monitor *Monitor) watch(layout *Layout, progress []Progress, snapshot func() []float64, abort func()) (stop func() error) {
+	done := make(chan bool)
+	stopped := make(chan bool)
+	var err error
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(CHECK_INTERVAL)
+		defer ticker.Stop()
+		checked := int64(0)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			balanced, largest := int64(0), float64(0)
+			for w := range progress {
+				balanced += atomic.LoadInt64(&progress[w].balanced)
+				largest = math.Max(largest, atomicLoadFloat64(&progress[w].largest))
+			}
+			isFinite := !math.IsNaN(largest) && !math.IsInf(largest, 0)
+			if isFinite && balanced-checked < int64(layout.numJoints()) {
+				continue
+			}
+
+			checked, largest = balanced, 0
+			for w := range progress {
+				largest = math.Max(largest, math.Float64frombits(atomic.SwapUint64(&progress[w].largest, 0)))
+			}
+			if reason := monitor.check(largest); reason != "" {
+				err = divergenceError(layout, snapshot(), fmt.Sprintf("%d joint balances", balanced), reason)
+				abort()
+				return
+			}
+		}
+	}()
+	return func() error {
+		close(done)
+		<-stopped
+		if err != nil {
+			return err
+		}
+
+		//joints that turned NaN look balanced to the workers
+		return checkFinite(layout, snapshot())
+	}
+}
+
+// checkFinite is the last check of every solve, it looks at every end with
+// the locked joints that the unbalance checks leave out
+func checkFinite(layout *Layout, moment []float64) error {
+	for j := 0; j < layout.numJoints(); j++ {
+		for i := layout.offsets[j]; i < layout.offsets[j+1]; i++ {
+			if !isFinite(moment[i]) {
+				err := divergenceError(layout, moment, "the end", "moments are not finite")
+				if layout.isLocked[j] {
+					//named as well, though it is never balanced
+					locked := JointUnbalance{id: layout.nodeIDs[j], unbalance: jointUnbalance(layout, moment, j)}
+					err.joints = append([]JointUnbalance{locked}, err.joints...)
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func isFinite(values ...float64) bool {
+	for _, value := range values {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// exitOnDivergence stops a command whose solve did not converge
+func exitOnDivergence(err error) {
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+}
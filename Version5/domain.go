@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+//*******************DOMAIN DECOMPOSITION**********
+
+// The domain solver gives each worker one joint set of the partition as its
+// subdomain, grown along the members when the joints have no positions. In a
+// round every worker balances its subdomain to the tolerance on its own,
+// adding carry-overs to its own joints at once and keeping those for other
+// subdomains in an outbox. At the end of the round the workers meet, every
+// worker takes the carry-overs sent to it and the solve ends after a round in
+// which nothing crossed a subdomain boundary. Only the interface carry-overs
+// of a whole round are exchanged, not every single one.
+
+// Subdomain is the state of one worker
+type Subdomain struct {
+	dirty   []int //joints to check in the next sweep
+	isDirty []bool
+	outbox  [][]Update //carry-overs by receiving worker
+	sweeps  int
+	sent    int64
+	failure string //why the subdomain stopped balancing, "" when it did not
+}
+
+func analyseStructureDomains(structure *Structure) {
+	layout := newLayout(structure)
+	rounds, err := analyseLayoutDomains(layout, 4)
+	exitOnDivergence(err)
+	layout.writeBack()
+	fmt.Println("Domain Analyse Finish, Rounds: ", rounds)
+}
+
+func analyseLayoutDomains(layout *Layout, numWorkers int) (rounds int, err error) {
+	//round robin would put most neighbours in other subdomains
+	var jointSets [][]int
+	if layout.coords != nil {
+		jointSets = layout.partition(numWorkers)
+	} else {
+		jointSets = layout.grow(numWorkers)
+	}
+	owner := layout.owners(jointSets)
+	domains := make([]Subdomain, numWorkers)
+	for w := range domains {
+		domains[w].dirty = append([]int(nil), jointSets[w]...)
+		domains[w].isDirty = make([]bool, layout.numJoints())
+		for _, j := range jointSets[w] {
+			domains[w].isDirty[j] = true
+		}
+		domains[w].outbox = make([][]Update, numWorkers)
+	}
+
+	largest := make([]float64, numWorkers)
+	parallel := func(work func(w int)) {
+		var wg sync.WaitGroup
+		for w := range domains {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				work(w)
+			}(w)
+		}
+		wg.Wait()
+	}
+
+	var monitor Monitor
+	for {
+		rounds++
+		parallel(func(w int) { domains[w].balance(layout, owner, w) })
+		for w := range domains {
+			if domains[w].failure != "" {
+				when := fmt.Sprintf("sweep %d of worker %d", domains[w].sweeps, w)
+				return rounds, divergenceError(layout, layout.moment, when, domains[w].failure)
+			}
+		}
+
+		parallel(func(w int) { largest[w] = domains[w].receive(layout, domains, w) })
+		sent, worst := int64(0), float64(0)
+		for w := range domains {
+			sent += domains[w].sent
+			domains[w].sent = 0
+			worst = math.Max(worst, largest[w])
+		}
+		if layout.telemetry != nil {
+			layout.telemetry.count(0, sent)
+			layout.telemetry.report(layout, layout.moment)
+		}
+		if sent == 0 {
+			return rounds, checkFinite(layout, layout.moment)
+		}
+		if reason := monitor.check(worst); reason != "" {
+			return rounds, divergenceError(layout, layout.moment, fmt.Sprintf("round %d", rounds), reason)
+		}
+	}
+}
+
+// balance sweeps the dirty joints of the subdomain until none is out of
+// balance, a carry-over to a joint of the subdomain makes it dirty
+func (domain *Subdomain) balance(layout *Layout, owner []int, w int) {
+	var monitor Monitor
+	var next []int
+	for len(domain.dirty) > 0 {
+		domain.sweeps++
+		balanced := int64(0)
+		largest := float64(0)
+		for _, j := range domain.dirty {
+			domain.isDirty[j] = false
+		}
+		for _, j := range domain.dirty {
+			if layout.isLocked[j] {
+				continue
+			}
+
+			//calculate amount of unbalance
+			momentSum := float64(0)
+			for i := layout.offsets[j]; i < layout.offsets[j+1]; i++ {
+				momentSum += layout.moment[i]
+			}
+			largest = math.Max(largest, math.Abs(momentSum))
+
+			//redistribute moment and carry over, across the boundary by outbox
+			if math.Abs(momentSum) > TOLERANCE {
+				balanced++
+				for i := layout.offsets[j]; i < layout.offsets[j+1]; i++ {
+					increment := -momentSum * layout.df[i]
+					layout.moment[i] += increment
+					if layout.cof[i] == 0 {
+						continue
+					}
+					far := layout.farJoint[i]
+					if v := owner[far]; v != w {
+						domain.outbox[v] = append(domain.outbox[v], Update{increment * layout.cof[i], layout.farEnd[i], far})
+						domain.sent++
+						continue
+					}
+					layout.moment[layout.farEnd[i]] += increment * layout.cof[i]
+					if !domain.isDirty[far] && !layout.isLocked[far] {
+						domain.isDirty[far] = true
+						next = append(next, far)
+					}
+				}
+			}
+		}
+		if layout.telemetry != nil {
+			layout.telemetry.count(balanced, 0)
+		}
+		if domain.failure = monitor.check(largest); domain.failure != "" {
+			return
+		}
+		domain.dirty, next = next, domain.dirty[:0]
+	}
+}
+
+// receive takes the carry-overs the other workers sent to w and returns the
+// largest unbalance they left at its joints
+func (domain *Subdomain) receive(layout *Layout, domains []Subdomain, w int) (largest float64) {
+	for v := range domains {
+		updates := domains[v].outbox[w]
+		for _, update := range updates {
+			layout.moment[update.endIndex] += update.carryover
+			if !domain.isDirty[update.joint] && !layout.isLocked[update.joint] {
+				domain.isDirty[update.joint] = true
+				domain.dirty = append(domain.dirty, update.joint)
+			}
+		}
+		domains[v].outbox[w] = updates[:0]
+	}
+	for _, j := range domain.dirty {
+		momentSum := float64(0)
+		for i := layout.offsets[j]; i < layout.offsets[j+1]; i++ {
+			momentSum += layout.moment[i]
+		}
+		largest = math.Max(largest, math.Abs(momentSum))
+	}
+	return largest
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+//*******************GEOMETRY***********************
+
+const (
+	MAX_SWAY_DOF        = 1500 //sway check is a dense elimination, skipped above this
+	COLLINEAR_TOLERANCE = 1e-6
+)
+
+// memberGeometry returns the length and unit direction node1 -> node2 of a
+// member, ok is false unless both nodes have coordinates
+func (structure *Structure) memberGeometry(member *Member) (length float64, direction [3]float64, ok bool) {
+	node1 := structure.nodeMap[member.node1]
+	node2 := structure.nodeMap[member.node2]
+	if !node1.hasCoords || !node2.hasCoords {
+		return 0, direction, false
+	}
+
+	d := [3]float64{node2.x - node1.x, node2.y - node1.y, node2.z - node1.z}
+	length = math.Sqrt(d[0]*d[0] + d[1]*d[1] + d[2]*d[2])
+	if length == 0 {
+		return 0, direction, false
+	}
+	for k := range d {
+		direction[k] = d[k] / length
+	}
+	return length, direction, true
+}
+
+// spanLength is the length of a member for loads along it, 1 without geometry
+func (structure *Structure) spanLength(member *Member) float64 {
+	if length, _, ok := structure.memberGeometry(member); ok {
+		return length
+	}
+	return 1
+}
+
+// computeStiffness replaces the df columns of members that have an EI and
+// known geometry with the member stiffness 4EI/L
+func computeStiffness(structure *Structure) {
+	for _, member := range structure.members() {
+		if member.end1.ei <= 0 {
+			continue
+		}
+		if length, _, ok := structure.memberGeometry(member); ok {
+			member.end1.df = 4 * member.end1.ei / length
+			member.end2.df = 4 * member.end2.ei / length
+		}
+	}
+}
+
+// hasBeamStiffness tells whether computeStiffness gave a member its stiffness
+// 4EI/L, else df1 and df2 are relative stiffnesses as given
+func (structure *Structure) hasBeamStiffness(member *Member) bool {
+	_, _, ok := structure.memberGeometry(member)
+	return ok && member.end1.ei > 0
+}
+
+// hasCoords tells whether every node of the structure has a position
+func (structure *Structure) hasCoords() bool {
+	for _, node := range structure.nodeMap {
+		if !node.hasCoords {
+			return false
+		}
+	}
+	return len(structure.nodeMap) > 0
+}
+
+// dimension is 3 when any node leaves the x-y plane, else 2
+func (structure *Structure) dimension() int {
+	for _, node := range structure.nodeMap {
+		if node.z != 0 {
+			return 3
+		}
+	}
+	return 2
+}
+
+//*******************SWAY**************************
+
+// translationRestrained tells whether a plain joint is held against
+// translation: it is taken as a support when all its members are collinear (a
+// beam line over a support), and as a free frame joint when they meet at an
+// angle.
+func (structure *Structure) translationRestrained(node Node) bool {
+	var first [3]float64
+	hasFirst := false
+	for _, end := range node.ends {
+		if end.member < 0 {
+			continue
+		}
+		member := &Member{node1: node.id, node2: end.otherEndNodeID}
+		_, direction, ok := structure.memberGeometry(member)
+		if !ok {
+			return true
+		}
+		if !hasFirst {
+			first, hasFirst = direction, true
+			continue
+		}
+		cross := [3]float64{
+			first[1]*direction[2] - first[2]*direction[1],
+			first[2]*direction[0] - first[0]*direction[2],
+			first[0]*direction[1] - first[1]*direction[0]}
+		if math.Abs(cross[0])+math.Abs(cross[1])+math.Abs(cross[2]) > COLLINEAR_TOLERANCE {
+			return false
+		}
+	}
+	return true
+}
+
+// swayModes counts the independent joint translations the members cannot
+// prevent when they are taken as axially rigid. Moment distribution without
+// sway correction is only exact when it is zero. ok is false when the model
+// has no coordinates or is too large to check.
+func swayModes(structure *Structure) (modes int, swayNodes []int, ok bool) {
+	if !structure.hasCoords() {
+		return 0, nil, false
+	}
+	dim := structure.dimension()
+
+	//unknown translations, column[id][k] is -1 along a restrained axis
+	column := make(map[int][3]int)
+	ids := make([]int, 0)
+	for id := range structure.nodeMap {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	numDOF := 0
+	for _, id := range ids {
+		restrained := structure.restrainedAxes(structure.nodeMap[id])
+		columns := [3]int{-1, -1, -1}
+		isFree := false
+		for k := 0; k < dim; k++ {
+			if !restrained[k] {
+				columns[k] = numDOF
+				numDOF++
+				isFree = true
+			}
+		}
+		if isFree {
+			column[id] = columns
+			swayNodes = append(swayNodes, id)
+		}
+	}
+	if numDOF == 0 {
+		return 0, nil, true
+	}
+	if numDOF > MAX_SWAY_DOF {
+		return 0, swayNodes, false
+	}
+
+	//one row per member: (u2 - u1) . direction = 0
+	rows := [][]float64{}
+	for _, member := range structure.members() {
+		_, direction, _ := structure.memberGeometry(member)
+		row := make([]float64, numDOF)
+		used := false
+		for k := 0; k < dim; k++ {
+			if c := columnOf(column, member.node1, k); c >= 0 {
+				row[c] -= direction[k]
+				used = true
+			}
+			if c := columnOf(column, member.node2, k); c >= 0 {
+				row[c] += direction[k]
+				used = true
+			}
+		}
+		if used {
+			rows = append(rows, row)
+		}
+	}
+
+	return numDOF - matrixRank(rows, numDOF), swayNodes, true
+}
+
+func columnOf(column map[int][3]int, id int, axis int) int {
+	if columns, ok := column[id]; ok {
+		return columns[axis]
+	}
+	return -1
+}
+
+// matrixRank runs Gaussian elimination with partial pivoting on rows
+func matrixRank(rows [][]float64, numColumns int) (rank int) {
+	for c := 0; c < numColumns && rank < len(rows); c++ {
+		pivot := rank
+		for r := rank + 1; r < len(rows); r++ {
+			if math.Abs(rows[r][c]) > math.Abs(rows[pivot][c]) {
+				pivot = r
+			}
+		}
+		if math.Abs(rows[pivot][c]) < COLLINEAR_TOLERANCE {
+			continue
+		}
+		rows[rank], rows[pivot] = rows[pivot], rows[rank]
+		for r := rank + 1; r < len(rows); r++ {
+			factor := rows[r][c] / rows[rank][c]
+			if factor == 0 {
+				continue
+			}
+			for k := c; k < numColumns; k++ {
+				rows[r][k] -= factor * rows[rank][k]
+			}
+		}
+		rank++
+	}
+	return rank
+}
+
+// writeGeometry lists member lengths and orientations and the sway check
+func writeGeometry(w io.Writer, structure *Structure) error {
+	for _, member := range structure.members() {
+		length, direction, ok := structure.memberGeometry(member)
+		if !ok {
+			fmt.Fprintf(w, "member %d (%d-%d): no geometry\n", member.id, member.node1, member.node2)
+			continue
+		}
+		angle := math.Atan2(direction[1], direction[0]) * 180 / math.Pi
+		fmt.Fprintf(w, "member %d (%d-%d): length %.3f, angle %.1f deg, direction (%.3f, %.3f, %.3f), stiffness %.3f\n",
+			member.id, member.node1, member.node2, length, angle, direction[0], direction[1], direction[2], member.end1.stiffness)
+	}
+
+	modes, swayNodes, ok := swayModes(structure)
+	switch {
+	case !ok && !structure.hasCoords():
+		fmt.Fprintln(w, "sway: not checked, some nodes have no coordinates")
+	case !ok:
+		fmt.Fprintf(w, "sway: not checked, %d free joints is above the limit\n", len(swayNodes))
+	case modes > 0:
+		fmt.Fprintf(w, "sway: %d mode(s), free joints %v, results need a sway correction\n", modes, swayNodes)
+	default:
+		fmt.Fprintln(w, "sway: none, the joints are held against translation")
+	}
+	return nil
+}
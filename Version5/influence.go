@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//*******************INFLUENCE LINES***************
+
+// PointLoad is a downward load p at distance a from node1 of a member
+type PointLoad struct {
+	member int
+	a, p   float64
+}
+
+// pointLoadMoments superposes the end moments of point loads from the unit
+// responses of their members. A load p at a from end 1 of a member of length
+// L = a + b has the fixed end moments -p a b^2 / L^2 and p a^2 b / L^2.
+func pointLoadMoments(structure *Structure, responses [][2][][2]float64, loads []PointLoad) [][2]float64 {
+	moments := make([][2]float64, len(structure.memberList))
+	for _, load := range loads {
+		length := structure.spanLength(structure.memberList[load.member])
+		b := length - load.a
+		fem := [2]float64{-load.p * load.a * b * b / (length * length), load.p * load.a * load.a * b / (length * length)}
+		for side := 0; side < 2; side++ {
+			for k, m := range responses[load.member][side] {
+				moments[k][0] += fem[side] * m[0]
+				moments[k][1] += fem[side] * m[1]
+			}
+		}
+	}
+	return moments
+}
+
+// reaction is the upward force of the members at a node of a beam line: end 1
+// of a member takes (p b - m1 - m2) / L of each load on it and end 2 the rest
+func reaction(structure *Structure, id int, moments [][2]float64, loads []PointLoad) (force float64) {
+	for _, member := range structure.members() {
+		if member.node1 != id && member.node2 != id {
+			continue
+		}
+		length := structure.spanLength(member)
+		shear := (moments[member.id][0] + moments[member.id][1]) / length
+		end1, end2 := -shear, shear
+		for _, load := range loads {
+			if load.member == member.id {
+				end1 += load.p * (length - load.a) / length
+				end2 += load.p * load.a / length
+			}
+		}
+		if member.node1 == id {
+			force += end1
+		}
+		if member.node2 == id {
+			force += end2
+		}
+	}
+	return force
+}
+
+// InfluenceTarget is the quantity an influence line is drawn for: the moment
+// at an end of a member or the reaction at a node
+type InfluenceTarget struct {
+	isReaction bool
+	member     int
+	side       int
+	node       int
+}
+
+// parseTarget reads moment:<member>:<1|2> or reaction:<node>
+func parseTarget(structure *Structure, spec string) (*InfluenceTarget, error) {
+	parts := strings.Split(spec, ":")
+	target := new(InfluenceTarget)
+	switch {
+	case len(parts) == 3 && parts[0] == "moment":
+		id, err := strconv.Atoi(parts[1])
+		if err != nil || id < 0 || id >= len(structure.memberList) {
+			return nil, fmt.Errorf("target %q: no member %q", spec, parts[1])
+		}
+		if parts[2] != "1" && parts[2] != "2" {
+			return nil, fmt.Errorf("target %q: end must be 1 or 2", spec)
+		}
+		target.member = id
+		target.side = int(parts[2][0] - '1')
+	case len(parts) == 2 && parts[0] == "reaction":
+		id, err := strconv.Atoi(parts[1])
+		if _, ok := structure.nodeMap[id]; err != nil || !ok {
+			return nil, fmt.Errorf("target %q: no node %q", spec, parts[1])
+		}
+		target.isReaction = true
+		target.node = id
+	default:
+		return nil, fmt.Errorf("target %q must be moment:<member>:<1|2> or reaction:<node>", spec)
+	}
+	return target, nil
+}
+
+func (target *InfluenceTarget) String() string {
+	if target.isReaction {
+		return fmt.Sprintf("reaction at node %d", target.node)
+	}
+	return fmt.Sprintf("moment at end %d of member %d", target.side+1, target.member)
+}
+
+func (target *InfluenceTarget) value(structure *Structure, moments [][2]float64, loads []PointLoad) float64 {
+	if target.isReaction {
+		return reaction(structure, target.node, moments, loads)
+	}
+	return moments[target.member][target.side]
+}
+
+// InfluencePoint is one ordinate of an influence line, the unit load sits at
+// t (0 to 1) along member, x is its position in the drawing of the structure
+type InfluencePoint struct {
+	member   int
+	t, x     float64
+	ordinate float64
+}
+
+// influenceLine moves a unit load across every member in samples steps. Each
+// member is solved twice, for a unit fixed end moment at either end, and
+// every position is superposed from those.
+func influenceLine(structure *Structure, target *InfluenceTarget, samples int, solver string) []InfluencePoint {
+	ids := make([]int, len(structure.memberList))
+	for k := range ids {
+		ids[k] = k
+	}
+	responses := unitResponses(structure, ids, solver)
+	positions := nodePositions(structure)
+
+	points := []InfluencePoint{}
+	for _, member := range structure.members() {
+		length := structure.spanLength(member)
+		p1, p2 := positions[member.node1], positions[member.node2]
+		for k := 0; k <= samples; k++ {
+			t := float64(k) / float64(samples)
+			loads := []PointLoad{{member.id, t * length, 1}}
+			moments := pointLoadMoments(structure, responses, loads)
+			points = append(points, InfluencePoint{member.id, t, p1.x + t*(p2.x-p1.x),
+				target.value(structure, moments, loads)})
+		}
+	}
+	return points
+}
+
+// writeInfluenceCSV lists the ordinates, one line per load position
+func writeInfluenceCSV(w io.Writer, points []InfluencePoint) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "member,t,x,ordinate")
+	for _, point := range points {
+		fmt.Fprintf(out, "%d,%.4f,%.4f,%.6f\n", point.member, point.t, point.x, point.ordinate)
+	}
+	return out.Flush()
+}
+
+// writeInfluenceSVG plots the ordinates against x, positive values up
+func writeInfluenceSVG(w io.Writer, target *InfluenceTarget, points []InfluencePoint) error {
+	minX, maxX := math.Inf(1), math.Inf(-1)
+	maxOrdinate := float64(0)
+	for _, point := range points {
+		minX, maxX = math.Min(minX, point.x), math.Max(maxX, point.x)
+		maxOrdinate = math.Max(maxOrdinate, math.Abs(point.ordinate))
+	}
+	if len(points) == 0 {
+		minX, maxX = 0, 1
+	}
+	if maxOrdinate == 0 {
+		maxOrdinate = 1
+	}
+	scaleX := (SVG_WIDTH - 2*SVG_MARGIN) / math.Max(maxX-minX, 1e-9)
+	scaleY := (SVG_PANEL/2 - SVG_MARGIN) / maxOrdinate
+	axis := SVG_PANEL / 2
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f" font-family="sans-serif" font-size="11">`+"\n",
+		SVG_WIDTH, SVG_PANEL, SVG_WIDTH, SVG_PANEL)
+	fmt.Fprintf(out, `<rect width="100%%" height="100%%" fill="white"/>`+"\n")
+	fmt.Fprintf(out, `<text x="%.1f" y="24" font-size="14">Influence line: %s</text>`+"\n", SVG_MARGIN, target)
+	fmt.Fprintf(out, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="black"/>`+"\n",
+		SVG_MARGIN, axis, SVG_WIDTH-SVG_MARGIN, axis)
+
+	//one polyline per member, the line may jump at a joint
+	for start := 0; start < len(points); {
+		end := start
+		fmt.Fprint(out, `<polyline fill="none" stroke="#3060c0" stroke-width="2" points="`)
+		for ; end < len(points) && points[end].member == points[start].member; end++ {
+			fmt.Fprintf(out, "%.1f,%.1f ", SVG_MARGIN+(points[end].x-minX)*scaleX, axis-points[end].ordinate*scaleY)
+		}
+		fmt.Fprintln(out, `"/>`)
+		start = end
+	}
+
+	//label the peaks
+	for _, point := range points {
+		if math.Abs(point.ordinate) == maxOrdinate {
+			x, y := SVG_MARGIN+(point.x-minX)*scaleX, axis-point.ordinate*scaleY
+			fmt.Fprintf(out, `<text x="%.1f" y="%.1f" fill="#3060c0" text-anchor="middle">%.3f</text>`+"\n",
+				x, y-6*sign(point.ordinate), point.ordinate)
+			break
+		}
+	}
+	fmt.Fprintln(out, "</svg>")
+	return out.Flush()
+}
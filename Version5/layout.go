@@ -0,0 +1,203 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+//****************FLAT LAYOUT****************
+
+// Layout is a compressed (CSR style) copy of a Structure used by the solvers.
+// Joint j owns the ends [offsets[j], offsets[j+1]) of the flat end arrays, and
+// every end knows the flat index of its far end, so a sweep never hashes.
+type Layout struct {
+	nodeIDs  []int
+	isLocked []bool //joints that are never balanced, see Node.isLocked
+	offsets  []int
+
+	df       []float64
+	cof      []float64
+	moment   []float64
+	farEnd   []int
+	farJoint []int
+
+	ends []*End //source ends, results are written back to them
+
+	coords [][3]float64 //joint positions, nil unless every node has one
+
+	trace     *Trace     //balancing steps of the sequential solver, nil when not traced
+	telemetry *Telemetry //convergence reports of the solvers, nil when not observed
+}
+
+func (layout *Layout) numJoints() int {
+	return len(layout.nodeIDs)
+}
+
+func (layout *Layout) numEnds() int {
+	return len(layout.df)
+}
+
+func newLayout(structure *Structure) *Layout {
+	//joints in id order so that runs are repeatable
+	ids := make([]int, 0, len(structure.nodeMap))
+	for id := range structure.nodeMap {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return newLayoutOf(structure, ids)
+}
+
+// newLayoutOf lays out the nodes ids, which must hold the far node of every
+// one of their ends
+func newLayoutOf(structure *Structure, ids []int) *Layout {
+	layout := new(Layout)
+	jointIndex := make(map[int]int, len(ids))
+	numEnds := 0
+	for j, id := range ids {
+		jointIndex[id] = j
+		numEnds += len(structure.nodeMap[id].ends)
+	}
+
+	layout.nodeIDs = ids
+	layout.isLocked = make([]bool, len(ids))
+	layout.offsets = make([]int, len(ids)+1)
+	layout.df = make([]float64, numEnds)
+	layout.cof = make([]float64, numEnds)
+	layout.moment = make([]float64, numEnds)
+	layout.farEnd = make([]int, numEnds)
+	layout.farJoint = make([]int, numEnds)
+	layout.ends = make([]*End, numEnds)
+
+	if structure.hasCoords() {
+		layout.coords = make([][3]float64, len(ids))
+		for j, id := range ids {
+			node := structure.nodeMap[id]
+			layout.coords[j] = [3]float64{node.x, node.y, node.z}
+		}
+	}
+
+	//first pass: offsets
+	for j, id := range ids {
+		node := structure.nodeMap[id]
+		layout.isLocked[j] = node.isLocked()
+		layout.offsets[j+1] = layout.offsets[j] + len(structure.nodeMap[id].ends)
+	}
+
+	//second pass: copy ends, endIndex keeps its position inside the joint
+	for j, id := range ids {
+		node := structure.nodeMap[id]
+		for endIndex, end := range node.ends {
+			i := layout.offsets[j] + endIndex
+			far := jointIndex[end.otherEndNodeID]
+			layout.df[i] = end.df
+			layout.cof[i] = end.cof
+			layout.moment[i] = end.moment
+			layout.farJoint[i] = far
+			layout.farEnd[i] = layout.offsets[far] + end.otherEndIndex
+			layout.ends[i] = end
+		}
+	}
+	return layout
+}
+
+// writeBack copies the solved moments into the Structure the layout was built from
+func (layout *Layout) writeBack() {
+	for i, end := range layout.ends {
+		end.moment = layout.moment[i]
+	}
+}
+
+// partition assigns joints to workers, by recursive coordinate bisection when
+// the joints have positions so that neighbours share a worker, else round robin
+func (layout *Layout) partition(numWorkers int) (jointSets [][]int) {
+	jointSets = make([][]int, numWorkers)
+	if layout.coords != nil {
+		joints := make([]int, layout.numJoints())
+		for j := range joints {
+			joints[j] = j
+		}
+		layout.bisect(joints, jointSets)
+		return jointSets
+	}
+
+	for j := 0; j < layout.numJoints(); j++ {
+		jointSets[j%numWorkers] = append(jointSets[j%numWorkers], j)
+	}
+	return jointSets
+}
+
+// grow assigns joints to workers in breadth first runs along the members, for
+// joints with no positions. Each set continues from the edge of the one before
+// it, so that neighbours share a worker.
+func (layout *Layout) grow(numWorkers int) (jointSets [][]int) {
+	jointSets = make([][]int, numWorkers)
+	isAssigned := make([]bool, layout.numJoints())
+	var queue []int
+	head, seed := 0, 0
+	for w := range jointSets {
+		size := layout.numJoints()*(w+1)/numWorkers - layout.numJoints()*w/numWorkers
+		for len(jointSets[w]) < size {
+			if head == len(queue) {
+				//a new connected part
+				for isAssigned[seed] {
+					seed++
+				}
+				queue = append(queue[:0], seed)
+				head = 0
+			}
+			j := queue[head]
+			head++
+			if isAssigned[j] {
+				continue
+			}
+			isAssigned[j] = true
+			jointSets[w] = append(jointSets[w], j)
+			for i := layout.offsets[j]; i < layout.offsets[j+1]; i++ {
+				if !isAssigned[layout.farJoint[i]] {
+					queue = append(queue, layout.farJoint[i])
+				}
+			}
+		}
+	}
+	return jointSets
+}
+
+// bisect splits joints across the longest extent of their bounding box, in
+// proportion to the number of sets each half fills
+func (layout *Layout) bisect(joints []int, jointSets [][]int) {
+	if len(jointSets) == 1 {
+		jointSets[0] = append(jointSets[0], joints...)
+		return
+	}
+
+	axis, extent := 0, -1.0
+	for k := 0; k < 3; k++ {
+		low, high := math.Inf(1), math.Inf(-1)
+		for _, j := range joints {
+			low = math.Min(low, layout.coords[j][k])
+			high = math.Max(high, layout.coords[j][k])
+		}
+		if high-low > extent {
+			axis, extent = k, high-low
+		}
+	}
+	sort.SliceStable(joints, func(a, b int) bool {
+		return layout.coords[joints[a]][axis] < layout.coords[joints[b]][axis]
+	})
+
+	half := len(jointSets) / 2
+	cut := len(joints) * half / len(jointSets)
+	layout.bisect(joints[:cut], jointSets[:half])
+	layout.bisect(joints[cut:], jointSets[half:])
+}
+
+// owners maps every joint to the worker whose joint set holds it
+func (layout *Layout) owners(jointSets [][]int) (owner []int) {
+	owner = make([]int, layout.numJoints())
+	for w, jointSet := range jointSets {
+		for _, j := range jointSet {
+			owner[j] = w
+		}
+	}
+	return owner
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+//*******************LOAD CASES********************
+
+// BASE_CASE holds the moments of the beam lines and everything superposed on them
+const BASE_CASE = "base"
+
+// LoadCase is a set of fixed end moments distributed on its own
+type LoadCase struct {
+	name    string
+	moments [][2]float64 //fixed end moments of every member at end1 and end2
+}
+
+// Imposed is a settlement (downward) or a clockwise rotation forced on a node
+type Imposed struct {
+	node       int
+	settlement float64
+	rotation   float64
+	loadCase   *LoadCase
+}
+
+// loadCase returns the case of that name, added when it is new; an empty name
+// is the base case
+func (structure *Structure) loadCase(name string) *LoadCase {
+	if name == "" {
+		name = BASE_CASE
+	}
+	for _, loadCase := range structure.loadCases {
+		if loadCase.name == name {
+			return loadCase
+		}
+	}
+	loadCase := &LoadCase{name, make([][2]float64, len(structure.memberList))}
+	structure.loadCases = append(structure.loadCases, loadCase)
+	return loadCase
+}
+
+// readImposed reads SETTLE <node> <delta> [case] and ROTATE <node> <theta> [case]
+func readImposed(structure *Structure, fields []string) error {
+	if len(fields) < 3 || len(fields) > 4 {
+		return fmt.Errorf("%s needs a node and a value: %v", fields[0], fields)
+	}
+	id, err := strconv.Atoi(fields[1])
+	if _, ok := structure.nodeMap[id]; err != nil || !ok {
+		return fmt.Errorf("%s: no node %q", fields[0], fields[1])
+	}
+	value, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return fmt.Errorf("%s: %v", fields[0], err)
+	}
+	name := ""
+	if len(fields) == 4 {
+		name = fields[3]
+	}
+
+	imposed := Imposed{node: id, loadCase: structure.loadCase(name)}
+	if fields[0] == "SETTLE" {
+		imposed.settlement = value
+	} else {
+		imposed.rotation = value
+	}
+	structure.imposed = append(structure.imposed, imposed)
+	return nil
+}
+
+// applyImposed adds the fixed end moments of the settlements and rotations to
+// their cases. It needs the member stiffness k = 4EI/L before releases and
+// supports change it, a member without EI and coordinates only has a relative
+// stiffness and is refused. A settlement turns the chord of a member by psi, the
+// difference of the settlements at node2 and node1 over the length, and gives
+// -6EI psi / L = -1.5 k psi at both ends. A rotation theta of a held node gives
+// k theta at the near end and half of it at the far end.
+func applyImposed(structure *Structure) error {
+	for _, imposed := range structure.imposed {
+		node := structure.nodeMap[imposed.node]
+		if imposed.rotation != 0 && !node.isFixed && node.support != SUPPORT_GUIDED {
+			return fmt.Errorf("ROTATE: node %d is not held against rotation", node.id)
+		}
+
+		for _, member := range structure.members() {
+			if member.node1 != node.id && member.node2 != node.id {
+				continue
+			}
+			if !structure.hasBeamStiffness(member) {
+				keyword := "SETTLE"
+				if imposed.rotation != 0 {
+					keyword = "ROTATE"
+				}
+				return fmt.Errorf("%s: member %d at node %d needs EI and coordinates", keyword, member.id, node.id)
+			}
+			moments := &imposed.loadCase.moments[member.id]
+
+			if imposed.settlement != 0 {
+				length, direction, ok := structure.memberGeometry(member)
+				axes, isPlane := structure.planeAxes()
+				if !ok || !isPlane {
+					return fmt.Errorf("SETTLE: member %d at node %d needs plane coordinates", member.id, node.id)
+				}
+				relative := imposed.settlement
+				if member.node1 == node.id {
+					relative = -relative
+				}
+				psi := relative * direction[axes[0]] / length
+				moments[0] -= 1.5 * member.end1.df * psi
+				moments[1] -= 1.5 * member.end2.df * psi
+			}
+
+			if imposed.rotation != 0 {
+				near, far, k := 0, 1, member.end1.df
+				if member.node2 == node.id {
+					near, far, k = 1, 0, member.end2.df
+				}
+				moments[near] += k * imposed.rotation
+				moments[far] += k * imposed.rotation * DEFAULT_CARRYOVER
+			}
+		}
+	}
+	return nil
+}
+
+// setMoments loads fixed end moments into the ends, clears the spring ends
+// and releases what the hinges and supports cannot carry
+func (structure *Structure) setMoments(moments [][2]float64) {
+	for _, node := range structure.nodeMap {
+		for _, end := range node.ends {
+			if end.member < 0 {
+				end.moment = 0
+			}
+		}
+	}
+	for _, member := range structure.members() {
+		member.end1.moment = moments[member.id][0]
+		member.end2.moment = moments[member.id][1]
+	}
+	releaseMoments(structure)
+	for _, member := range structure.members() {
+		member.end1.fem = member.end1.moment
+		member.end2.fem = member.end2.moment
+	}
+}
+
+// endMoments copies the end moments of every member
+func (structure *Structure) endMoments() [][2]float64 {
+	moments := make([][2]float64, len(structure.memberList))
+	for _, member := range structure.members() {
+		moments[member.id] = [2]float64{member.end1.moment, member.end2.moment}
+	}
+	return moments
+}
+
+// solveMoments distributes one set of fixed end moments and returns the end
+// moments of every member
+func solveMoments(structure *Structure, moments [][2]float64, solver string) [][2]float64 {
+	structure.setMoments(moments)
+	solveStructure(structure, solver)
+	return structure.endMoments()
+}
+
+// CaseResult is the distributed end moments of one load case
+type CaseResult struct {
+	name    string
+	moments [][2]float64
+}
+
+// solveLoadCases distributes every case in turn and leaves the structure with
+// the base case
+func solveLoadCases(structure *Structure, solver string) []CaseResult {
+	results := make([]CaseResult, 0, len(structure.loadCases))
+	for _, loadCase := range structure.loadCases {
+		results = append(results, CaseResult{loadCase.name, solveMoments(structure, loadCase.moments, solver)})
+	}
+	base := results[0].moments
+	for _, member := range structure.members() {
+		member.end1.moment = base[member.id][0]
+		member.end2.moment = base[member.id][1]
+	}
+	return results
+}
+
+// writeLoadCases lists the members of every case, the base case alone is
+// written as by writeMembers
+func writeLoadCases(w io.Writer, structure *Structure, results []CaseResult) error {
+	for _, result := range results {
+		if len(results) > 1 {
+			fmt.Fprintf(w, "load case %s\n", result.name)
+		}
+		writeMemberMoments(w, structure, result.moments)
+	}
+	return nil
+}
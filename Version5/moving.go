@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+//*******************MOVING LOADS******************
+
+// AxleTrain is a vehicle of axle loads, spacings[k] is the distance between
+// axle k and axle k+1
+type AxleTrain struct {
+	loads    []float64
+	spacings []float64
+}
+
+// readTrain reads TRAIN <p1> [<s1> <p2> [<s2> <p3>]...], axle loads from the
+// front with the spacings between them
+func readTrain(structure *Structure, fields []string) error {
+	if len(fields) < 2 || len(fields)%2 != 0 {
+		return fmt.Errorf("TRAIN needs axle loads with a spacing between each two: %v", fields)
+	}
+	train := new(AxleTrain)
+	for k, field := range fields[1:] {
+		value, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return fmt.Errorf("TRAIN: %v", err)
+		}
+		if k%2 == 0 {
+			train.loads = append(train.loads, value)
+		} else if value < 0 {
+			return fmt.Errorf("TRAIN: negative spacing %v", value)
+		} else {
+			train.spacings = append(train.spacings, value)
+		}
+	}
+	structure.train = train
+	return nil
+}
+
+// offsets are the distances of the axles behind the front one
+func (train *AxleTrain) offsets() []float64 {
+	offsets := []float64{0}
+	for _, spacing := range train.spacings {
+		offsets = append(offsets, offsets[len(offsets)-1]+spacing)
+	}
+	return offsets
+}
+
+// MovingEffect is the largest and smallest value of one quantity and where
+// the front axle was when the train gave it
+type MovingEffect struct {
+	max, min         float64
+	maxAt, minAt     float64
+	maxBack, minBack bool //the train ran from the far end
+	isSet            bool
+}
+
+func (effect *MovingEffect) add(value, at float64, isBack bool) {
+	if !effect.isSet || value > effect.max {
+		effect.max, effect.maxAt, effect.maxBack = value, at, isBack
+	}
+	if !effect.isSet || value < effect.min {
+		effect.min, effect.minAt, effect.minBack = value, at, isBack
+	}
+	effect.isSet = true
+}
+
+// MovingResult holds the effects at every member end and every node
+type MovingResult struct {
+	step      float64
+	ends      [][2]MovingEffect
+	reactions map[int]*MovingEffect
+	nodeIDs   []int
+}
+
+// moveTrain runs the train across the members in input order, each from node1
+// to node2, in steps of the shortest span over samples and in both directions.
+// The members are solved once for unit end moments and every position is
+// superposed, see influenceLine.
+func moveTrain(structure *Structure, samples int, solver string) (*MovingResult, error) {
+	if len(structure.memberList) == 0 {
+		return nil, fmt.Errorf("TRAIN needs members to run on")
+	}
+	train := structure.train
+	ids := make([]int, len(structure.memberList))
+	starts := make([]float64, len(structure.memberList)+1)
+	step := 0.0
+	for k, member := range structure.members() {
+		ids[k] = k
+		length := structure.spanLength(member)
+		starts[k+1] = starts[k] + length
+		if step == 0 || length/float64(samples) < step {
+			step = length / float64(samples)
+		}
+	}
+	responses := unitResponses(structure, ids, solver)
+
+	result := &MovingResult{step: step, ends: make([][2]MovingEffect, len(ids)), reactions: make(map[int]*MovingEffect)}
+	for id := range structure.nodeMap {
+		result.nodeIDs = append(result.nodeIDs, id)
+		result.reactions[id] = new(MovingEffect)
+	}
+	sort.Ints(result.nodeIDs)
+
+	total := starts[len(ids)]
+	offsets := train.offsets()
+	length := offsets[len(offsets)-1]
+	for _, isBack := range []bool{false, true} {
+		for k := 0; float64(k)*step <= total+length+step/2; k++ {
+			front := float64(k) * step
+			loads := []PointLoad{}
+			for axle, offset := range offsets {
+				at := front - offset
+				if isBack {
+					at = total - at
+				}
+				if at < 0 || at > total {
+					continue
+				}
+				member := sort.SearchFloat64s(starts[1:], at)
+				if member >= len(ids) {
+					member = len(ids) - 1
+				}
+				loads = append(loads, PointLoad{member, at - starts[member], train.loads[axle]})
+			}
+			if len(loads) == 0 {
+				continue
+			}
+
+			moments := pointLoadMoments(structure, responses, loads)
+			for id := range ids {
+				result.ends[id][0].add(moments[id][0], front, isBack)
+				result.ends[id][1].add(moments[id][1], front, isBack)
+			}
+			for _, id := range result.nodeIDs {
+				result.reactions[id].add(reaction(structure, id, moments, loads), front, isBack)
+			}
+		}
+	}
+	return result, nil
+}
+
+func direction(isBack bool) string {
+	if isBack {
+		return "back"
+	}
+	return "forward"
+}
+
+// writeMoving lists the largest effects of the train, the position is that of
+// the front axle from the start of the run, "back" runs from the far end
+func writeMoving(w io.Writer, structure *Structure, result *MovingResult) error {
+	fmt.Fprintf(w, "train %v spacings %v, step %.3f\n", structure.train.loads, structure.train.spacings, result.step)
+	for _, member := range structure.members() {
+		for side := 0; side < 2; side++ {
+			effect := result.ends[member.id][side]
+			fmt.Fprintf(w, "member %d (%d-%d) end %d: max %10.2f at %.2f %s min %10.2f at %.2f %s\n",
+				member.id, member.node1, member.node2, side+1,
+				effect.max, effect.maxAt, direction(effect.maxBack), effect.min, effect.minAt, direction(effect.minBack))
+		}
+	}
+	for _, id := range result.nodeIDs {
+		effect := result.reactions[id]
+		fmt.Fprintf(w, "node %d reaction: max %10.2f at %.2f %s min %10.2f at %.2f %s\n",
+			id, effect.max, effect.maxAt, direction(effect.maxBack), effect.min, effect.minAt, direction(effect.minBack))
+	}
+	return nil
+}
@@ -4,11 +4,15 @@ import (
 	//"container/list"
 	"fmt"
 	"bufio"
+	"io"
 	"os"
 	"math"
 	"strconv"
+	"strings"
 	"flag"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,31 +21,34 @@ import (
 const (
 	TOLERANCE = 0.1
 	TOLERANCE_CHECK = 0.2
-	BUFFER_SIZE = 20
 )
 
-var REPORT_CHANNEL = make(chan bool, 1e6)	
-var FINISH_CHANNEL = make(chan bool)
-
 type Structure struct {
 	nodeMap map[int]Node
+	memberList []*Member //in input order, see members
+	loadCases []*LoadCase //the base case first
+	imposed []Imposed
+	misfits []Misfit
+	combinations []*Combination
+	liveLoad *LiveLoad
+	train *AxleTrain
+	modules []*Module //repeated substructures, see solveCondensed
 }
 
 type Node struct {
 	id int
 	isFixed bool
+	support Support
+	springStiffness float64
 	ends map[int] *End
-	buffer chan *Update
+	x, y, z float64 //optional position, see hasCoords
+	hasCoords bool
 }
 
 func (node *Node) String() (result string) {
 	result = fmt.Sprintf("Node id: %d, num of ends: %d", node.id, len(node.ends))
 	
-	if node.isFixed {
-		result += ", Fix"
-	} else {
-		result += ", Non-fix"
-	}
+	result += ", " + node.support.String()
 	
 	for _, end := range node.ends {
 		result += "\n\t" + end.String()
@@ -60,30 +67,62 @@ func newNode(id int, isFixed bool) *Node {
 	
 	node.id = id
 	node.isFixed = isFixed
+	if isFixed {
+		node.support = SUPPORT_FIXED
+	}
 	node.ends = make(map[int]*End)
-	node.buffer = make(chan *Update, BUFFER_SIZE)
 	return node
 }
 
+// Update carries a moment to the flat end index of a Layout. It is sent by
+// value so a carry-over never allocates.
 type Update struct {
 	carryover float64
 	endIndex int
+	joint int
 }
 
-func newUpdate(endIndex int, carryover float64) *Update {
-	update := new(Update)
-	
-	update.endIndex = endIndex
-	update.carryover = carryover
-	
-	return update
+// Mailbox collects the updates sent to the joints of one worker. Posting
+// never blocks, the worker is woken through wake.
+type Mailbox struct {
+	mu sync.Mutex
+	updates []Update
+	wake chan bool
+}
+
+func (mailbox *Mailbox) post(update Update) {
+	mailbox.mu.Lock()
+	mailbox.updates = append(mailbox.updates, update)
+	mailbox.mu.Unlock()
+
+	select {
+	case mailbox.wake <- true:
+	default:
+	}
+}
+
+// take returns the posted updates and keeps spare as the next buffer, so the
+// two slices are reused once they have grown
+func (mailbox *Mailbox) take(spare []Update) (updates []Update) {
+	mailbox.mu.Lock()
+	updates = mailbox.updates
+	mailbox.updates = spare[:0]
+	mailbox.mu.Unlock()
+	return updates
 }
 
 type End struct {
 	otherEndNodeID int
 	otherEndIndex int
 	df float64
+	cof float64 //carry-over factor to the other end
 	moment float64
+	member int //beam line in the input file, stable between runs
+	side int //0 at node1 of the member, 1 at node2
+	ei float64 //flexural rigidity of the member, 0 when not given
+	released bool //internal hinge, the end carries no moment
+	fem float64 //fixed end moment after releases, see setMoments
+	stiffness float64 //df before normalization
 }
 
 func (end *End) String() (result string) {
@@ -91,24 +130,267 @@ func (end *End) String() (result string) {
 	return result
 }
 
+// Member is a beam line of the input file with its two ends
+type Member struct {
+	id int
+	node1, node2 int
+	end1, end2 *End
+	removed bool //taken out by removeMember, its ends have no stiffness
+}
+
+// members lists the members of the structure in input order
+func (structure *Structure) members() []*Member {
+	return structure.memberList
+}
+
 func printStructure(structure *Structure) {
 	for _, node := range structure.nodeMap {
 		fmt.Println(node.String())
 	}
 }
 
+// writeMembers lists the end moments of every member in input order
+func writeMembers(w io.Writer, structure *Structure) error {
+	return writeMemberMoments(w, structure, structure.endMoments())
+}
+
+func writeMemberMoments(w io.Writer, structure *Structure, moments [][2]float64) error {
+	for _, member := range structure.members() {
+		if member.removed {
+			continue
+		}
+		fmt.Fprintf(w, "member %d (%d-%d): %10.2f %10.2f\n",
+			member.id, member.node1, member.node2, moments[member.id][0], moments[member.id][1])
+	}
+	return nil
+}
+
 //**********************MAIN***********************
 
 func main() {
 	//Set Number of Cores
 	var numCores = flag.Int("n", 4, "number of CPU cores to use")
+	var filename = flag.String("f", "Node1e4.txt", "input file")
+	var solver = flag.String("solver", "channel", "solver: channel, atomic, steal, domain, or sequential for commands")
+	var absTolerance = flag.Float64("atol", TOLERANCE_CHECK, "absolute tolerance when comparing end moments")
+	var relTolerance = flag.Float64("rtol", 0, "relative tolerance when comparing end moments")
+	var format = flag.String("format", "text", "report format: text or json for comparisons, csv or svg for influence lines, text, csv or html for tables")
+	var reportFile = flag.String("report", "", "write the comparison report to this file instead of stdout")
+	var output = flag.String("o", "", "output file of a command")
+	var target = flag.String("target", "", "influence line of moment:<member>:<1|2> or reaction:<node>")
+	var checkpoint = flag.String("checkpoint", "", "solve saves a checkpoint to this file")
+	var every = flag.Duration("every", 10*time.Second, "interval between checkpoints")
+	var resume = flag.String("resume", "", "solve resumes from this checkpoint")
+	var edits = flag.String("edits", "", "edit file of the edit command")
+	var slice = flag.Duration("slice", time.Millisecond, "time slice of the telemetry of the parallel solvers")
+	var jobs = flag.Int("jobs", 4, "components solved at the same time")
+	var samples = flag.Int("samples", 20, "load positions per member of an influence line or axle train")
+	var bench = flag.Int("bench", 0, "benchmark map and flat layout sweeps for given rounds")
+	var benchParallel = flag.Int("benchpar", 0, "benchmark the parallel solvers for given rounds")
 	flag.Parse()
 	runtime.GOMAXPROCS(*numCores)
 	
-	filename := "Node1e4.txt"
+	if *bench > 0 {
+		benchmarkLayout(*filename, *bench)
+		return
+	}
+	if *benchParallel > 0 {
+		benchmarkParallel(*filename, *benchParallel)
+		return
+	}
+	
+	//Commands=================================================
+	switch flag.Arg(0) {
+	case "":
+	case "svg":
+		structure := loadStructure(*filename)
+		solveStructure(structure, *solver)
+		if err := writeOutput(*output, func(w io.Writer) error { return writeDiagramSVG(w, structure) }); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		return
+	case "solve":
+		structure := loadStructure(*filename)
+		if *checkpoint != "" || *resume != "" {
+			//long runs of the base case
+			if err := analyseStructureCheckpointed(structure, *solver, *checkpoint, *every, *resume); err != nil {
+				fmt.Println(err)
+				os.Exit(2)
+			}
+			if err := writeOutput(*output, func(w io.Writer) error { return writeMembers(w, structure) }); err != nil {
+				fmt.Println(err)
+				os.Exit(2)
+			}
+			return
+		}
+		results := solveLoadCases(structure, *solver)
+		if err := writeOutput(*output, func(w io.Writer) error { return writeCombinations(w, structure, results) }); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		return
+	case "patterns":
+		structure := loadStructure(*filename)
+		if structure.liveLoad == nil {
+			fmt.Println("No LIVE load in", *filename)
+			os.Exit(2)
+		}
+		effects := patternEnvelope(structure, *solver)
+		if err := writeOutput(*output, func(w io.Writer) error { return writePatterns(w, structure, effects) }); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		return
+	case "influence":
+		structure := loadStructure(*filename)
+		influenceTarget, err := parseTarget(structure, *target)
+		if err != nil || *samples < 1 {
+			fmt.Println("influence:", err, "samples:", *samples)
+			os.Exit(2)
+		}
+		points := influenceLine(structure, influenceTarget, *samples, *solver)
+		write := func(w io.Writer) error { return writeInfluenceCSV(w, points) }
+		if *format == "svg" {
+			write = func(w io.Writer) error { return writeInfluenceSVG(w, influenceTarget, points) }
+		}
+		if err := writeOutput(*output, write); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		return
+	case "moving":
+		structure := loadStructure(*filename)
+		if structure.train == nil || *samples < 1 {
+			fmt.Println("No TRAIN in", *filename, "or samples below 1")
+			os.Exit(2)
+		}
+		result, err := moveTrain(structure, *samples, *solver)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		if err := writeOutput(*output, func(w io.Writer) error { return writeMoving(w, structure, result) }); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		return
+	case "edit":
+		structure := loadStructure(*filename)
+		start := time.Now()
+		layout := newLayout(structure)
+		exitOnDivergence(analyseLayoutWith(layout, *solver))
+		layout.writeBack()
+		fmt.Fprintf(os.Stderr, "Full solve took %s\n", time.Since(start))
+		touched, err := applyEdits(structure, *edits)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		start = time.Now()
+		layout, err = analyseLayoutEdited(layout, structure, touched)
+		exitOnDivergence(err)
+		fmt.Fprintf(os.Stderr, "Warm start solve took %s\n", time.Since(start))
+		layout.writeBack()
+		if err := writeOutput(*output, func(w io.Writer) error { return writeMembers(w, structure) }); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		return
+	case "table":
+		//Hardy Cross table of the sequential solve
+		structure := loadStructure(*filename)
+		layout := newLayout(structure)
+		layout.trace = newTrace(layout)
+		_, err := analyseLayoutSequential(layout, nil)
+		exitOnDivergence(err)
+		layout.writeBack()
+		table := layout.trace.table(layout)
+		if err := writeOutput(*output, func(w io.Writer) error { return table.write(w, *format) }); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		return
+	case "telemetry":
+		//convergence of one solve as CSV
+		structure := loadStructure(*filename)
+		if *slice <= 0 {
+			fmt.Println("Time slice", *slice, "must be positive")
+			os.Exit(2)
+		}
+		layout := newLayout(structure)
+		log := &TelemetryLog{solver: *solver}
+		layout.telemetry = newTelemetry(log, *slice)
+		err := analyseLayoutWith(layout, *solver)
+		layout.writeBack()
+		if err := writeOutput(*output, log.writeCSV); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		exitOnDivergence(err)
+		return
+	case "components":
+		//every connected part on its own
+		structure := loadStructure(*filename)
+		if *jobs < 1 {
+			fmt.Println("Jobs", *jobs, "must be at least 1")
+			os.Exit(2)
+		}
+		results := solveComponents(structure, *solver, *jobs)
+		if err := writeOutput(*output, func(w io.Writer) error { return writeComponents(w, structure, results) }); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		return
+	case "condense":
+		//static condensation of the MODULE substructures
+		structure := loadStructure(*filename)
+		if len(structure.modules) == 0 {
+			fmt.Println("No MODULE in", *filename)
+			os.Exit(2)
+		}
+		start := time.Now()
+		sweeps, err := solveCondensed(structure)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "Condensed solve took %s, %d interface sweeps\n", time.Since(start), sweeps)
+		if err := writeOutput(*output, func(w io.Writer) error { return writeMembers(w, structure) }); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		return
+	case "validate":
+		structure, findings := readStructureFile(*filename)
+		if structure == nil && countSeverity(findings, SEVERITY_ERROR) == 0 {
+			os.Exit(2)
+		}
+		if err := writeOutput(*output, func(w io.Writer) error { return writeFindings(w, findings) }); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		if structure == nil {
+			os.Exit(1)
+		}
+		return
+	case "geometry":
+		structure := loadStructure(*filename)
+		if err := writeOutput(*output, func(w io.Writer) error { return writeGeometry(w, structure) }); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		return
+	default:
+		fmt.Println("Unknown command:", flag.Arg(0))
+		os.Exit(2)
+	}
 	
 	//Sequential Version===========================================
-	structure1 := createStructureFromFile(filename)
+	structure1 := loadStructure(*filename)
+	if modes, swayNodes, ok := swayModes(structure1); ok && modes > 0 {
+		fmt.Printf("Warning: %d sway mode(s) at joints %v are not corrected\n", modes, swayNodes)
+	}
 	start := time.Now()
     
 	analyseStructureSequential(structure1)
@@ -117,28 +399,131 @@ func main() {
 	fmt.Printf("Sequential version took %s\n", elapsed)
 	
 	//Parallel Version=========================================
-	structure2 := createStructureFromFile(filename)
+	structure2 := loadStructure(*filename)
 
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
 	start = time.Now()
 
-	analyseStructureAsynchronous(structure2)
+	analyseStructureWith(structure2, *solver)
 
 	elapsed = time.Since(start)
+	runtime.ReadMemStats(&after)
 	fmt.Printf("Parallel version took %s\n", elapsed)
+	fmt.Printf("Parallel version allocated %d objects, %d GC cycles\n", after.Mallocs - before.Mallocs, after.NumGC - before.NumGC)
 	
 	//printStructure(structure2)
 	
 	//Check Correctness========================================
-	if checkStructure(structure1, structure2) {
-		fmt.Println("Same")
-	} else {
-		fmt.Println("Not Same")
+	comparison := compareStructures(structure1, structure2, *absTolerance, *relTolerance)
+	report := os.Stdout
+	if *reportFile != "" {
+		var err error
+		if report, err = os.Create(*reportFile); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		defer report.Close()
+	}
+	if err := comparison.write(report, *format); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	if !comparison.Same {
+		os.Exit(1)
 	}
 }
 
+// analyseStructureWith runs the solver named by the -solver flag
+func analyseStructureWith(structure *Structure, solver string) {
+	switch solver {
+	case "sequential":
+		analyseStructureSequential(structure)
+	case "atomic":
+		analyseStructureAtomic(structure)
+	case "steal":
+		analyseStructureStealing(structure)
+	case "domain":
+		analyseStructureDomains(structure)
+	default:
+		analyseStructureAsynchronous(structure)
+	}
+}
+
+// analyseLayoutWith runs the named solver on a layout the caller writes back
+func analyseLayoutWith(layout *Layout, solver string) error {
+	var err error
+	switch solver {
+	case "sequential":
+		_, err = analyseLayoutSequential(layout, nil)
+	case "atomic":
+		err = analyseLayoutAtomic(layout, 4)
+	case "steal":
+		err = analyseLayoutStealing(layout, 4)
+	case "domain":
+		_, err = analyseLayoutDomains(layout, 4)
+	default:
+		_, err = analyseLayoutAsynchronous(layout, nil)
+	}
+	return err
+}
+
+// solveStructure runs the named solver without the progress line of
+// analyseStructureWith, for commands that write their results to stdout
+func solveStructure(structure *Structure, solver string) {
+	layout := newLayout(structure)
+	exitOnDivergence(analyseLayoutWith(layout, solver))
+	layout.writeBack()
+}
+
+// writeOutput hands write the named file, or stdout when filename is empty
+func writeOutput(filename string, write func(w io.Writer) error) error {
+	if filename == "" {
+		return write(os.Stdout)
+	}
+	outputFile, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err = write(outputFile); err != nil {
+		outputFile.Close()
+		return err
+	}
+	return outputFile.Close()
+}
+
 //*******************CONSTRUCT STRUCTURE***********
 
-func createStructureFromFile(filename string) (structure *Structure) {
+// loadStructure reads the input file of a command and exits when it is unusable
+func loadStructure(filename string) *Structure {
+	structure := createStructureFromFile(filename)
+	if structure == nil {
+		os.Exit(2)
+	}
+	return structure
+}
+
+// createStructureFromFile reads a model and refuses it when validation finds
+// an error, the warnings are only counted
+func createStructureFromFile(filename string) *Structure {
+	structure, findings := readStructureFile(filename)
+	for _, finding := range findings {
+		if finding.severity == SEVERITY_ERROR {
+			fmt.Println(finding)
+		}
+	}
+	if structure == nil {
+		return nil
+	}
+	if warnings := countSeverity(findings, SEVERITY_WARNING); warnings > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %d validation warning(s), see the validate command\n", warnings)
+	}
+	return structure
+}
+
+// readStructureFile builds the structure, it is nil when the file is unusable
+// or validation finds an error
+func readStructureFile(filename string) (structure *Structure, findings []Finding) {
 	inputFile, inputError := os.Open(filename)
 	if inputError != nil {
 		fmt.Println(inputError)
@@ -147,71 +532,193 @@ func createStructureFromFile(filename string) (structure *Structure) {
 	}
 	defer inputFile.Close()
 	scanner := bufio.NewScanner(inputFile) 
-	scanner.Split(bufio.ScanWords)
 	
 	structure = new(Structure)
 	structure.nodeMap = make(map[int]Node)
+	base := structure.loadCase(BASE_CASE)
+
+	//a number that does not parse is a finding, where says on which line
+	number := func(field string, where string, k int) float64 {
+		value, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			findings = append(findings, Finding{SEVERITY_ERROR, "number", fmt.Sprintf("%s %d: %v", where, k, err)})
+		}
+		return value
+	}
+	integer := func(field string, where string, k int) int {
+		value, err := strconv.Atoi(field)
+		if err != nil {
+			findings = append(findings, Finding{SEVERITY_ERROR, "number", fmt.Sprintf("%s %d: %v", where, k, err)})
+		}
+		return value
+	}
 	
 	//read number of nodes
-	scanner.Scan()
-	numNodes, _ := strconv.Atoi(scanner.Text())
+	fields, _ := readFields(scanner)
+	numNodes := integer(fields[0], "line", 1)
 		
-	//read nodes
+	//read nodes: id F/N [x y [z]]
 	for i := 0; i < numNodes; i++ {
-		scanner.Scan()
-		id, _ := strconv.Atoi(scanner.Text())
-		scanner.Scan()
-		isFixed := scanner.Text() == "F"
-		structure.nodeMap[id] = *newNode(id, isFixed)
+		fields, numGiven := readFields(scanner)
+		id := integer(fields[0], "node line", i+1)
+		support, springStiffness, err := parseSupport(fields[1])
+		if err != nil {
+			findings = append(findings, Finding{SEVERITY_ERROR, "support", fmt.Sprintf("node %d: %v", id, err)})
+		}
+		node := newNode(id, support == SUPPORT_FIXED)
+		node.support = support
+		node.springStiffness = springStiffness
+		if numGiven >= 4 {
+			node.hasCoords = true
+			node.x = number(fields[2], "node", id)
+			node.y = number(fields[3], "node", id)
+			if numGiven >= 5 {
+				node.z = number(fields[4], "node", id)
+			}
+		}
+		structure.nodeMap[id] = *node
 	}
 	
 	//read number of ends
-	scanner.Scan()
-	numEnds, _ := strconv.Atoi(scanner.Text())
+	fields, _ = readFields(scanner)
+	numEnds := integer(fields[0], "member count after node line", numNodes)
 		
-	//read ends
+	//read ends: node1 df1 cof1 moment1 node2 df2 cof2 moment2 [EI]
 	for i := 0; i < numEnds; i++ {
-		scanner.Scan()
-		id1, _ := strconv.Atoi(scanner.Text())
-		scanner.Scan()
-		df1, _ := strconv.ParseFloat(scanner.Text(), 64)
-		scanner.Scan()
-		//_ := strconv.ParseFloat(scanner.Text(), 64)
-		scanner.Scan()
-		moment1, _ := strconv.ParseFloat(scanner.Text(), 64)
-		scanner.Scan()
-		id2, _ := strconv.Atoi(scanner.Text())
-		scanner.Scan()
-		df2, _ := strconv.ParseFloat(scanner.Text(), 64)
-		scanner.Scan()
-		//_ := strconv.ParseFloat(scanner.Text(), 64)
-		scanner.Scan()
-		moment2, _ := strconv.ParseFloat(scanner.Text(), 64)
+		fields, numGiven := readFields(scanner)
+		id1 := integer(fields[0], "member", i)
+		df1 := number(fields[1], "member", i)
+		moment1 := number(fields[3], "member", i)
+		id2 := integer(fields[4], "member", i)
+		df2 := number(fields[5], "member", i)
+		moment2 := number(fields[7], "member", i)
+		isListed := true
+		for _, id := range []int{id1, id2} {
+			if _, ok := structure.nodeMap[id]; !ok {
+				findings = append(findings, Finding{SEVERITY_ERROR, "node", fmt.Sprintf("member %d joins node %d that is not in the node list", i, id)})
+				isListed = false
+			}
+		}
+		if !isListed {
+			continue
+		}
 		
-		connectNodes(structure, id1, df1, moment1, id2, df2, moment2)
+		end1, end2 := connectNodes(structure, id1, df1, moment1, id2, df2, moment2)
+		base.moments = append(base.moments, [2]float64{moment1, moment2})
+		if numGiven >= 9 {
+			end1.ei = number(fields[8], "member", i)
+			end2.ei = end1.ei
+		}
+	}
+	
+	if countSeverity(findings, SEVERITY_ERROR) > 0 {
+		//the keyword lines count members from 0 and would miss the left out ones
+		return nil, findings
+	}
+
+	//read optional keyword lines, see InputFormat
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if err := readKeyword(structure, fields); err != nil {
+			findings = append(findings, Finding{SEVERITY_ERROR, "keyword", err.Error()})
+		}
+	}
+	if countSeverity(findings, SEVERITY_ERROR) > 0 {
+		return nil, findings
 	}
 	
+	computeStiffness(structure)
+	findings = append(findings, validateStructure(structure)...)
+	if countSeverity(findings, SEVERITY_ERROR) > 0 {
+		return nil, findings
+	}
+	if err := checkCombinations(structure); err != nil {
+		return nil, append(findings, Finding{SEVERITY_ERROR, "combination", err.Error()})
+	}
+	if err := applyImposed(structure); err != nil {
+		return nil, append(findings, Finding{SEVERITY_ERROR, "imposed", err.Error()})
+	}
+	if err := applyMisfits(structure); err != nil {
+		return nil, append(findings, Finding{SEVERITY_ERROR, "misfit", err.Error()})
+	}
+	applyReleases(structure)
+	applySupports(structure)
 	normalizeStructure(structure)
+	structure.setMoments(base.moments)
 	
-	return
+	if modes, ends := mechanismModes(structure); modes > 0 {
+		message := fmt.Sprintf("the releases of members %v leave %d mechanism(s)", ends, modes)
+		return nil, append(findings, Finding{SEVERITY_ERROR, "release", message})
+	}
+	return structure, findings
 }
 
-func connectNodes(structure *Structure, id1 int, df1 float64, moment1 float64, id2 int, df2 float64, moment2 float64) {
+// readKeyword reads one line of the optional section after the beams
+func readKeyword(structure *Structure, fields []string) error {
+	switch fields[0] {
+	case "RELEASE":
+		return readRelease(structure, fields)
+	case "SETTLE", "ROTATE":
+		return readImposed(structure, fields)
+	case "THERMAL":
+		return readThermal(structure, fields)
+	case "MISFIT":
+		return readMisfit(structure, fields)
+	case "LOAD":
+		return readLoad(structure, fields)
+	case "COMBINATION":
+		return readCombination(structure, fields)
+	case "LIVE":
+		return readLive(structure, fields)
+	case "TRAIN":
+		return readTrain(structure, fields)
+	case "MODULE":
+		return readModule(structure, fields)
+	}
+	return fmt.Errorf("unknown keyword %q", fields[0])
+}
+
+// readFields returns the fields of the next non-empty line and how many were
+// given, padded so that a short or missing line reads as zeros
+func readFields(scanner *bufio.Scanner) (fields []string, numGiven int) {
+	for len(fields) == 0 && scanner.Scan() {
+		fields = strings.Fields(scanner.Text())
+	}
+	numGiven = len(fields)
+	for len(fields) < 8 {
+		fields = append(fields, "0")
+	}
+	return fields, numGiven
+}
+
+func connectNodes(structure *Structure, id1 int, df1 float64, moment1 float64, id2 int, df2 float64, moment2 float64) (end1, end2 *End) {
 	node1 := structure.nodeMap[id1]
-	end1 := new(End)
+	end1 = new(End)
 	
 	node2 := structure.nodeMap[id2]
-	end2 := new(End)
+	end2 = new(End)
 	
 	end1.df = df1
+	end1.cof = DEFAULT_CARRYOVER
 	end1.moment = moment1
+	end1.member = len(structure.memberList)
+	end1.side = 0
 	end1.otherEndNodeID = id2
 	end1.otherEndIndex = node2.addEnd(end2)
 	
 	end2.df = df2
+	end2.cof = DEFAULT_CARRYOVER
 	end2.moment = moment2
+	end2.member = len(structure.memberList)
+	end2.side = 1
 	end2.otherEndNodeID = id1
 	end2.otherEndIndex = node1.addEnd(end1)
+	
+	structure.memberList = append(structure.memberList, &Member{id: end1.member, node1: id1, node2: id2, end1: end1, end2: end2})
+	return end1, end2
 }
 
 func normalizeStructure(structure *Structure) {
@@ -224,7 +731,10 @@ func normalizeStructure(structure *Structure) {
 			}
 			
 			for _, end := range node.ends {
-				end.df /= dfSum
+				end.stiffness = end.df
+				if dfSum > 0 {
+					end.df /= dfSum
+				}
 			}
 		} else {
 			delete(structure.nodeMap, id)
@@ -235,183 +745,196 @@ func normalizeStructure(structure *Structure) {
 //*******************ANALYZE STRUCTURE SEQUENTIAL**
 
 func analyseStructureSequential(structure *Structure) {
+	layout := newLayout(structure)
+	iteration, err := analyseLayoutSequential(layout, nil)
+	exitOnDivergence(err)
+	layout.writeBack()
+	fmt.Println("Sequential Analyse Finish, Iteration: ", iteration)
+}
+
+// analyseLayoutSequential sweeps the joints until none is out of balance,
+// saving a checkpoint between sweeps when one is due
+func analyseLayoutSequential(layout *Layout, checkpointer *Checkpointer) (iteration int, err error) {
+	var monitor Monitor
 	isFinish := false
-	iteration := 0
 	for !isFinish {
+		if checkpointer != nil {
+			checkpointer.between(layout, int64(iteration))
+		}
 		iteration++
 		isFinish = true
-		for _, node := range structure.nodeMap { //default order
-			moreUpdate := true
-			for moreUpdate {
-				//check pending updated value
-					select {
-					case update, ok := <-node.buffer:
-						if ok {
-							node.ends[update.endIndex].moment += update.carryover
-						} else {
-							fmt.Println("Error: Channel closed!")
-							break
-						}
-					default:
-						moreUpdate = false
-						break
-				}
+		balanced, messages := int64(0), int64(0)
+		largest := float64(0)
+		for j := 0; j < layout.numJoints(); j++ {
+			if layout.isLocked[j] {
+				continue
 			}
-			
-			if !node.isFixed {
-				//calculate amount of unbalance
-				momentSum := float64(0)
-				for _, end := range node.ends {
-					momentSum += end.moment
-				}
-
-				//redistribute moment and carry over
-				if (math.Abs(momentSum) > TOLERANCE) {
-					isFinish = false
 
-					for _, end := range node.ends {			
-						increment := - momentSum * end.df
-						end.moment += increment
-						structure.nodeMap[end.otherEndNodeID].buffer <- newUpdate(end.otherEndIndex, increment * 0.5)
+			//calculate amount of unbalance
+			momentSum := float64(0)
+			for i := layout.offsets[j]; i < layout.offsets[j+1]; i++ {
+				momentSum += layout.moment[i]
+			}
+			largest = math.Max(largest, math.Abs(momentSum))
+
+			//redistribute moment and carry over
+			if math.Abs(momentSum) > TOLERANCE {
+				isFinish = false
+
+				for i := layout.offsets[j]; i < layout.offsets[j+1]; i++ {
+					increment := -momentSum * layout.df[i]
+					layout.moment[i] += increment
+					layout.moment[layout.farEnd[i]] += increment * layout.cof[i]
+					if layout.cof[i] != 0 {
+						messages++
 					}
 				}
+				balanced++
+				if layout.trace != nil {
+					layout.trace.record(layout, iteration, j, momentSum)
+				}
 			}
 		}
+		if layout.telemetry != nil {
+			layout.telemetry.count(balanced, messages)
+			layout.telemetry.report(layout, layout.moment)
+		}
+		if reason := monitor.check(largest); reason != "" {
+			return iteration, divergenceError(layout, layout.moment, fmt.Sprintf("sweep %d", iteration), reason)
+		}
 	}
-	fmt.Println("Sequential Analyse Finish, Iteration: ", iteration)
+	return iteration, checkFinite(layout, layout.moment)
 }
 
 //*******************ANALYZE STRUCTURE PARALLEL****
 
+// analyseStructureAsynchronous balances the joints with one goroutine per
+// joint set. Workers sleep on their mailbox until a carry-over arrives, and
+// the run ends when no update is in flight and no worker is busy.
 func analyseStructureAsynchronous(structure *Structure) {
-	idSets := make([]map[int]bool, 4)
-	idSets[0] = make(map[int]bool)
-	idSets[1] = make(map[int]bool)
-	idSets[2] = make(map[int]bool)
-	idSets[3] = make(map[int]bool)
-	rotate := 0
+	layout := newLayout(structure)
+	_, err := analyseLayoutAsynchronous(layout, nil)
+	exitOnDivergence(err)
+	layout.writeBack()
+	fmt.Println("Parallel Analyse Finish")
+}
+
+// analyseLayoutAsynchronous runs the workers and returns how many rounds they
+// made. Workers hold gate while they touch moments or post, so a checkpoint
+// taken under the write lock sees the moments and mailboxes between rounds.
+func analyseLayoutAsynchronous(layout *Layout, checkpointer *Checkpointer) (rounds int64, err error) {
+	jointSets := layout.partition(4)
+	owner := layout.owners(jointSets)
+	mailboxes := make([]Mailbox, len(jointSets))
+	for w := range mailboxes {
+		mailboxes[w].wake = make(chan bool, 1)
+	}
 
-	//assign nodes to threads
-	for id, _ := range structure.nodeMap {
-		idSets[rotate][id] = true
-		rotate = (rotate + 1) % 4
+	//every worker starts with one pending unit for its first sweep
+	pending := int64(len(jointSets))
+	finish := make(chan bool)
+	var once sync.Once
+	end := func() { once.Do(func() { close(finish) }) }
+	var gate sync.RWMutex
+	if checkpointer != nil {
+		go checkpointer.watch(layout, mailboxes, &gate, &rounds, finish)
+	}
+	if layout.telemetry != nil {
+		stop := layout.telemetry.sample(layout, mailboxSnapshot(layout, mailboxes, &gate))
+		defer stop()
 	}
+	var monitor Monitor
+	progress := make([]Progress, len(jointSets))
+	check := monitor.watch(layout, progress, mailboxSnapshot(layout, mailboxes, &gate), end)
 
 	//start parallel analysis
-	for i := 0; i < 4; i++ {
-		go analyseNode(structure, idSets[i])
+	var wg sync.WaitGroup
+	for w := range jointSets {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			analyseNode(layout, mailboxes, owner, w, jointSets[w], &pending, &rounds, &gate, &progress[w], finish, end)
+		}(w)
 	}
+	wg.Wait()
+	return rounds, check()
+}
 
-	All:
+// analyseNode is one worker, it reports each round to progress and end closes
+// finish once for the workers and the divergence check
+func analyseNode(layout *Layout, mailboxes []Mailbox, owner []int, w int, jointSet []int, pending *int64, rounds *int64, gate *sync.RWMutex, progress *Progress, finish chan bool, end func()) {
+	mailbox := &mailboxes[w]
+	touched := append([]int(nil), jointSet...)
+	isTouched := make([]bool, layout.numJoints())
+	received := int64(1)
+	var updates []Update
+
+	gate.RLock()
 	for {
-		//time.Sleep(1 * time.Millisecond)
-		for count := 0; count < len(structure.nodeMap); count++ {
-			select {
-			case _, ok := <- REPORT_CHANNEL:
-				if ok {
-					break
-				}
+		atomic.AddInt64(rounds, 1)
+		balanced, messages := int64(0), int64(0)
+		largest := float64(0)
+		for _, j := range touched {
+			if layout.isLocked[j] {
+				continue
 			}
-		}
 
-		isFinish := true
-		Scan:
-		for _, node := range structure.nodeMap { //default order
-			//check pending updated value
-			select {
-			case value, ok := <-node.buffer:
-				if ok {
-					node.buffer <- value
-					isFinish = false
-					break Scan
+			//calculate amount of unbalance for non-fixed ends
+			momentSum := float64(0)
+			for i := layout.offsets[j]; i < layout.offsets[j+1]; i++ {
+				momentSum += layout.moment[i]
+			}
+			largest = math.Max(largest, math.Abs(momentSum))
+
+			//redistribute moment and carry over
+			if math.Abs(momentSum) > TOLERANCE {
+				for i := layout.offsets[j]; i < layout.offsets[j+1]; i++ {
+					increment := -momentSum * layout.df[i]
+					layout.moment[i] += increment
+					if layout.cof[i] == 0 {
+						continue
+					}
+					far := layout.farJoint[i]
+					atomic.AddInt64(pending, 1)
+					mailboxes[owner[far]].post(Update{increment * layout.cof[i], layout.farEnd[i], far})
+					messages++
 				}
-			default:
-				break
+				balanced++
 			}
 		}
-
-		if isFinish {
-			close(FINISH_CHANNEL)
-			fmt.Println("Parallel Analyse Finish")
-			break All
+		progress.add(balanced, largest)
+		if layout.telemetry != nil {
+			layout.telemetry.count(balanced, messages)
 		}
-	}
-}
-
-func analyseNode(structure *Structure, idSet map[int]bool) {
-	AnalyseNode:
-	for {
-		//check whether analyse finish
-		select {
-		case _, ok := <- FINISH_CHANNEL:
-			if !ok {
-				break AnalyseNode
-			}
-		default:
-			break
-		}
-
-		for id, _ := range idSet {
-			node := structure.nodeMap[id]
-
-			moreUpdate := true
-			for moreUpdate {
-				//check pending updated value
-					select {
-					case update, ok := <-node.buffer:
-						if ok {
-							node.ends[update.endIndex].moment += update.carryover
-						} else {
-							fmt.Println("Error: Channel closed!")
-							break
-						}
-					default:
-						moreUpdate = false
-						break
-				}
-			}
 
-			if !node.isFixed {
-				//calculate amount of unbalance for non-fixed ends
-				momentSum := float64(0)
-				for _, end := range structure.nodeMap[id].ends {
-					momentSum += end.moment
-				}
+		gate.RUnlock()
 
-				//redistribute moment and carry over
-				if (math.Abs(momentSum) > TOLERANCE) {
-					for _, end := range structure.nodeMap[id].ends {
-						increment := - momentSum * end.df
-						end.moment += increment
-						structure.nodeMap[end.otherEndNodeID].buffer <- newUpdate(end.otherEndIndex, increment * 0.5)
-					}
-				}
-			}
+		//the updates just handled are no longer in flight
+		if received > 0 && atomic.AddInt64(pending, -received) == 0 {
+			end()
+			return
 		}
-		REPORT_CHANNEL <- true
-		//time.Sleep(1 * time.Millisecond)
-	}
-}
 
-//******************CHECK CORRECTNESS****************
+		//block until a carry-over arrives or the analysis is finished
+		select {
+		case <-mailbox.wake:
+		case <-finish:
+			return
+		}
 
-func checkStructure(structure1, structure2 *Structure) (isSame bool) {
-	isSame = true
-	
-	for id, _ := range structure1.nodeMap {
-		node1 := structure1.nodeMap[id]
-		node2 := structure2.nodeMap[id]
-		
-		for endIndex, _ := range node1.ends {
-			moment1 := node1.ends[endIndex].moment
-			moment2 := node2.ends[endIndex].moment
-			if (math.Abs(moment1 - moment2) > TOLERANCE_CHECK) {
-				fmt.Println(node1.String())
-				fmt.Println(node2.String())
-				isSame = false //return
-				break
+		gate.RLock()
+		updates = mailbox.take(updates)
+		received = int64(len(updates))
+		touched = touched[:0]
+		for _, update := range updates {
+			layout.moment[update.endIndex] += update.carryover
+			if !isTouched[update.joint] {
+				isTouched[update.joint] = true
+				touched = append(touched, update.joint)
 			}
 		}
+		for _, j := range touched {
+			isTouched[j] = false
+		}
 	}
-	return isSame
-}
\ No newline at end of file
+}
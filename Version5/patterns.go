@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+//*******************PATTERN LOADING***************
+
+// UNIT_MOMENT scales the unit fixed end moments so that the absolute TOLERANCE
+// of the solvers is small against them
+const UNIT_MOMENT = 1e6
+
+// LiveLoad is a uniform load that may be placed on any of its spans
+type LiveLoad struct {
+	intensity float64
+	spans     []int
+}
+
+// readLive reads LIVE <w> [member]..., a live load on the listed members or on
+// all of them
+func readLive(structure *Structure, fields []string) error {
+	if len(fields) < 2 {
+		return fmt.Errorf("LIVE needs a load: %v", fields)
+	}
+	intensity, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return fmt.Errorf("LIVE: %v", err)
+	}
+	live := &LiveLoad{intensity: intensity}
+	for _, field := range fields[2:] {
+		id, err := strconv.Atoi(field)
+		if err != nil || id < 0 || id >= len(structure.memberList) {
+			return fmt.Errorf("LIVE: no member %q", field)
+		}
+		live.spans = append(live.spans, id)
+	}
+	if len(live.spans) == 0 {
+		for _, member := range structure.members() {
+			live.spans = append(live.spans, member.id)
+		}
+	}
+	structure.liveLoad = live
+	return nil
+}
+
+// unitResponses distributes a unit fixed end moment at either end of each of
+// the members in turn. By superposition any load on member j with fixed end
+// moments fem1 and fem2 gives fem1 responses[j][0] + fem2 responses[j][1];
+// members that are not asked for are left nil.
+func unitResponses(structure *Structure, members []int, solver string) [][2][][2]float64 {
+	responses := make([][2][][2]float64, len(structure.memberList))
+	moments := make([][2]float64, len(structure.memberList))
+	for _, id := range members {
+		for side := 0; side < 2; side++ {
+			moments[id][side] = UNIT_MOMENT
+			response := solveMoments(structure, moments, solver)
+			for k := range response {
+				response[k][0] /= UNIT_MOMENT
+				response[k][1] /= UNIT_MOMENT
+			}
+			responses[id][side] = response
+			moments[id][side] = 0
+		}
+	}
+	return responses
+}
+
+// PatternEffect is the worst sum of span contributions to one moment, the
+// spans with a positive contribution give the max and the others the min
+type PatternEffect struct {
+	max, min           float64
+	maxSpans, minSpans []int
+}
+
+func (effect *PatternEffect) add(span int, value float64) {
+	switch {
+	case value > 0:
+		effect.max += value
+		effect.maxSpans = append(effect.maxSpans, span)
+	case value < 0:
+		effect.min += value
+		effect.minSpans = append(effect.minSpans, span)
+	}
+}
+
+// patternEnvelope places the live load on every pattern of spans at once:
+// with the load on each span solved once, the largest moment at a point loads
+// exactly the spans that raise it, so no pattern has to be enumerated. It
+// returns end 1, end 2 and the sagging moment in the middle of every member.
+func patternEnvelope(structure *Structure, solver string) [][3]PatternEffect {
+	live := structure.liveLoad
+	responses := unitResponses(structure, live.spans, solver)
+	effects := make([][3]PatternEffect, len(structure.memberList))
+	for _, span := range live.spans {
+		member := structure.memberList[span]
+		length := structure.spanLength(member)
+		fem := live.intensity * length * length / 12
+
+		for _, target := range structure.members() {
+			m1 := -fem*responses[span][0][target.id][0] + fem*responses[span][1][target.id][0]
+			m2 := -fem*responses[span][0][target.id][1] + fem*responses[span][1][target.id][1]
+			middle := (m1 - m2) / 2
+			if target.id == span {
+				middle += fem * 1.5
+			}
+			effects[target.id][0].add(span, m1)
+			effects[target.id][1].add(span, m2)
+			effects[target.id][2].add(span, middle)
+		}
+	}
+	return effects
+}
+
+// writePatterns lists the live load envelope of every member
+func writePatterns(w io.Writer, structure *Structure, effects [][3]PatternEffect) error {
+	fmt.Fprintf(w, "live load %.2f on spans %v\n", structure.liveLoad.intensity, structure.liveLoad.spans)
+	for _, member := range structure.members() {
+		for k, point := range []string{"end 1", "end 2", "middle"} {
+			effect := effects[member.id][k]
+			fmt.Fprintf(w, "member %d (%d-%d) %s: max %10.2f spans %v min %10.2f spans %v\n",
+				member.id, member.node1, member.node2, point, effect.max, effect.maxSpans, effect.min, effect.minSpans)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+//*******************RELEASES**********************
+
+// readRelease reads RELEASE <member> <1|2>, an internal hinge at end 1 (node1)
+// or end 2 (node2) of a member
+func readRelease(structure *Structure, fields []string) error {
+	if len(fields) != 3 {
+		return fmt.Errorf("RELEASE needs a member and an end: %v", fields)
+	}
+	id, err := strconv.Atoi(fields[1])
+	if err != nil || id < 0 || id >= len(structure.memberList) {
+		return fmt.Errorf("RELEASE: no member %q", fields[1])
+	}
+	member := structure.memberList[id]
+	switch fields[2] {
+	case "1":
+		member.end1.released = true
+	case "2":
+		member.end2.released = true
+	default:
+		return fmt.Errorf("RELEASE: end of member %d must be 1 or 2, not %q", id, fields[2])
+	}
+	return nil
+}
+
+// applyReleases adjusts the members with released ends before the supports
+// are applied. A released end has no stiffness and carries nothing over, the
+// other end then counts 3/4 of its stiffness; see releaseMoments for the
+// fixed end moments.
+func applyReleases(structure *Structure) {
+	for _, member := range structure.members() {
+		end1, end2 := member.end1, member.end2
+		switch {
+		case end1.released && end2.released:
+			end1.df, end2.df = 0, 0
+		case end2.released:
+			end1.df *= 0.75
+			end2.df = 0
+		case end1.released:
+			end2.df *= 0.75
+			end1.df = 0
+		default:
+			continue
+		}
+		end1.cof, end2.cof = 0, 0
+	}
+}
+
+// releaseMoments releases the fixed end moments once at every end that cannot
+// carry a moment, see releasedMoments
+func releaseMoments(structure *Structure) {
+	for _, member := range structure.members() {
+		moments := structure.releasedMoments(member, [2]float64{member.end1.moment, member.end2.moment})
+		member.end1.moment, member.end2.moment = moments[0], moments[1]
+	}
+}
+
+// releasedMoments releases the fixed end moments of a member at an internal
+// hinge or a released support, carrying half of it to the other end. A member
+// released at both ends only carries shear and the moment at a free tip is
+// left to the cantilever moment at its support.
+func (structure *Structure) releasedMoments(member *Member, moments [2]float64) [2]float64 {
+	ends := [2]*End{member.end1, member.end2}
+	nodes := [2]Node{structure.nodeMap[member.node1], structure.nodeMap[member.node2]}
+	if ends[0].released && ends[1].released {
+		return [2]float64{0, 0}
+	}
+	//hinges first, then the supports
+	for far := 0; far < 2; far++ {
+		if ends[far].released {
+			moments[1-far] -= moments[far] * DEFAULT_CARRYOVER
+			moments[far] = 0
+		}
+	}
+	for far := 0; far < 2; far++ {
+		near := 1 - far
+		switch {
+		case ends[far].released || !nodes[far].isReleased() || nodes[far].isFixed:
+		case ends[near].released || nodes[far].support == SUPPORT_FREE:
+			//a link or a free tip, the moment at the support is given
+			moments[far] = 0
+		default:
+			moments[near] -= moments[far] * DEFAULT_CARRYOVER
+			moments[far] = 0
+		}
+	}
+	return moments
+}
+
+//*******************MECHANISMS********************
+
+// kinematicSystem collects the compatibility rows of rigid members in the node
+// translations and rotations and the member rotations. Every motion it leaves
+// free is a mechanism.
+type kinematicSystem struct {
+	numDOF int
+	rows   []map[int]float64
+}
+
+func (system *kinematicSystem) newColumn() int {
+	system.numDOF++
+	return system.numDOF - 1
+}
+
+// addRow keeps the terms whose column is unknown, a column below 0 is held
+func (system *kinematicSystem) addRow(columns []int, coefficients []float64) {
+	row := make(map[int]float64)
+	for k, c := range columns {
+		if c >= 0 && coefficients[k] != 0 {
+			row[c] += coefficients[k]
+		}
+	}
+	if len(row) > 0 {
+		system.rows = append(system.rows, row)
+	}
+}
+
+func (system *kinematicSystem) rank() int {
+	rows := make([][]float64, len(system.rows))
+	for r, sparse := range system.rows {
+		rows[r] = make([]float64, system.numDOF)
+		for c, value := range sparse {
+			rows[r][c] = value
+		}
+	}
+	return matrixRank(rows, system.numDOF)
+}
+
+// rotationRestrained tells whether the support holds the node against rotation
+func (node Node) rotationRestrained() bool {
+	switch node.support {
+	case SUPPORT_FIXED, SUPPORT_GUIDED:
+		return true
+	case SUPPORT_SPRING:
+		return node.springStiffness > 0
+	}
+	return node.isFixed
+}
+
+// planeAxes returns the two axes of a plane model, x-y or x-z when no node
+// leaves y = 0, ok is false without coordinates or for a 3D model
+func (structure *Structure) planeAxes() (axes [2]int, ok bool) {
+	if !structure.hasCoords() {
+		return axes, false
+	}
+	hasY, hasZ := false, false
+	for _, node := range structure.nodeMap {
+		hasY = hasY || node.y != 0
+		hasZ = hasZ || node.z != 0
+	}
+	switch {
+	case hasY && hasZ:
+		return axes, false
+	case hasZ:
+		return [2]int{0, 2}, true
+	}
+	return [2]int{0, 1}, true
+}
+
+// mechanismModes counts the independent motions left when every member is
+// rigid and its released ends turn freely. It is only checked when some end
+// is released, and released lists those members. Plane models use their
+// coordinates, anything else is taken as a beam line that moves across y
+// only where the support lets it, with unit member lengths.
+func mechanismModes(structure *Structure) (modes int, released []int) {
+	for _, member := range structure.members() {
+		if member.end1.released || member.end2.released {
+			released = append(released, member.id)
+		}
+	}
+	if len(released) == 0 {
+		return 0, nil
+	}
+
+	axes, isPlane := structure.planeAxes()
+	if !isPlane {
+		axes = [2]int{1, 1}
+	}
+
+	//translation columns, a beam line only has the one across it
+	translation := make(map[int][2]int)
+	system := new(kinematicSystem)
+	for id, node := range structure.nodeMap {
+		restrained := structure.restrainedAxes(node)
+		columns := [2]int{-1, -1}
+		for k, axis := range axes {
+			if !restrained[axis] && (isPlane || k == 1) {
+				columns[k] = system.newColumn()
+			}
+		}
+		translation[id] = columns
+	}
+
+	//a member moves when one of its nodes can; on a beam line the others keep
+	//their ends and the joints between them still
+	isMoving := func(member *Member) bool {
+		t1, t2 := translation[member.node1], translation[member.node2]
+		return isPlane || t1[0] >= 0 || t1[1] >= 0 || t2[0] >= 0 || t2[1] >= 0
+	}
+	rotation := make(map[int]int)
+	for _, member := range structure.members() {
+		if !isMoving(member) {
+			continue
+		}
+		for _, id := range []int{member.node1, member.node2} {
+			if _, ok := rotation[id]; ok {
+				continue
+			}
+			rotation[id] = -1
+			node := structure.nodeMap[id]
+			if !node.rotationRestrained() && node.hasFixedEnd() {
+				rotation[id] = system.newColumn()
+			}
+		}
+	}
+	if system.numDOF > MAX_SWAY_DOF {
+		return 0, released
+	}
+
+	for _, member := range structure.members() {
+		rotation1, ok1 := rotation[member.node1]
+		rotation2, ok2 := rotation[member.node2]
+		if !ok1 {
+			rotation1 = -1
+		}
+		if !ok2 {
+			rotation2 = -1
+		}
+		if !isMoving(member) {
+			//the member keeps still, so do its joined ends
+			if !member.end1.released {
+				system.addRow([]int{rotation1}, []float64{1})
+			}
+			if !member.end2.released {
+				system.addRow([]int{rotation2}, []float64{1})
+			}
+			continue
+		}
+
+		theta := system.newColumn()
+		length, direction, ok := structure.memberGeometry(member)
+		if !isPlane || !ok {
+			length, direction = 1, [3]float64{1, 0, 0}
+		}
+		d := [2]float64{direction[axes[0]], direction[axes[1]]}
+		t1, t2 := translation[member.node1], translation[member.node2]
+		if isPlane {
+			//no stretch: (u2 - u1) . d = 0
+			system.addRow([]int{t1[0], t1[1], t2[0], t2[1]}, []float64{-d[0], -d[1], d[0], d[1]})
+		}
+		//rigid turn: (u2 - u1) . n = L theta with n = (-d1, d0)
+		system.addRow([]int{t1[0], t1[1], t2[0], t2[1], theta}, []float64{d[1], -d[0], -d[1], d[0], -length})
+		if !member.end1.released {
+			system.addRow([]int{rotation1, theta}, []float64{1, -1})
+		}
+		if !member.end2.released {
+			system.addRow([]int{rotation2, theta}, []float64{1, -1})
+		}
+	}
+	if system.numDOF > MAX_SWAY_DOF {
+		return 0, released
+	}
+	return system.numDOF - system.rank(), released
+}
+
+// hasFixedEnd tells whether a member end is joined rigidly to the node, the
+// rotation of a node whose ends are all released does not matter
+func (node Node) hasFixedEnd() bool {
+	for _, end := range node.ends {
+		if end.member >= 0 && !end.released {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+//*******************ANALYZE STRUCTURE STEALING****
+
+// Deque holds the joints of one worker that may be out of balance. The owner
+// works from the front, which keeps the sweep order that converges fastest,
+// and thieves take from the back.
+type Deque struct {
+	mu     sync.Mutex
+	joints []int
+	head   int //joints[:head] are already taken
+}
+
+func (deque *Deque) pushBack(j int) {
+	deque.mu.Lock()
+	//reuse the taken prefix instead of growing forever
+	if deque.head > 64 && deque.head > len(deque.joints)/2 {
+		n := copy(deque.joints, deque.joints[deque.head:])
+		deque.joints = deque.joints[:n]
+		deque.head = 0
+	}
+	deque.joints = append(deque.joints, j)
+	deque.mu.Unlock()
+}
+
+func (deque *Deque) popBack() (j int, ok bool) {
+	deque.mu.Lock()
+	defer deque.mu.Unlock()
+	if len(deque.joints) == deque.head {
+		return 0, false
+	}
+	j = deque.joints[len(deque.joints)-1]
+	deque.joints = deque.joints[:len(deque.joints)-1]
+	return j, true
+}
+
+func (deque *Deque) popFront() (j int, ok bool) {
+	deque.mu.Lock()
+	defer deque.mu.Unlock()
+	if len(deque.joints) == deque.head {
+		return 0, false
+	}
+	j = deque.joints[deque.head]
+	deque.head++
+	if deque.head == len(deque.joints) {
+		deque.joints = deque.joints[:0]
+		deque.head = 0
+	}
+	return j, true
+}
+
+// Joint states of the Scheduler. A joint is balanced by one worker at a time,
+// a carry-over to a busy joint has it balanced again by the same worker.
+const (
+	JOINT_IDLE int32 = iota
+	JOINT_QUEUED
+	JOINT_BUSY
+	JOINT_RERUN //busy, and received a carry-over since it read its unbalance
+)
+
+// Scheduler balances only joints that are queued. A joint is queued again
+// when it receives a carry-over, and the solve ends when no joint is queued
+// or being balanced.
+type Scheduler struct {
+	layout   *Layout
+	bits     []uint64
+	state    []int32
+	deques   []Deque
+	progress []Progress //for the divergence check
+
+	pending   int64 //joints queued or being balanced
+	available int64 //joints sitting in a deque
+	idle      int64 //workers waiting for work
+
+	mu      sync.Mutex
+	wakeup  *sync.Cond
+	done    bool
+	stopped int32 //set by finish for the busy workers
+}
+
+// newScheduler gives worker w the joints of jointSets[w] that start out of
+// balance, a worker with no joints steals from the others
+func newScheduler(layout *Layout, jointSets [][]int) *Scheduler {
+	numWorkers := len(jointSets)
+	s := new(Scheduler)
+	s.layout = layout
+	s.bits = make([]uint64, layout.numEnds())
+	for i, moment := range layout.moment {
+		s.bits[i] = math.Float64bits(moment)
+	}
+	s.state = make([]int32, layout.numJoints())
+	s.deques = make([]Deque, numWorkers)
+	s.progress = make([]Progress, numWorkers)
+	s.wakeup = sync.NewCond(&s.mu)
+
+	for w, jointSet := range jointSets {
+		for _, j := range jointSet {
+			if !layout.isLocked[j] && math.Abs(s.unbalance(j)) > TOLERANCE {
+				s.enqueue(w, j)
+			}
+		}
+	}
+	return s
+}
+
+func (s *Scheduler) unbalance(j int) (momentSum float64) {
+	for i := s.layout.offsets[j]; i < s.layout.offsets[j+1]; i++ {
+		momentSum += atomicLoadFloat64(&s.bits[i])
+	}
+	return momentSum
+}
+
+// enqueue puts joint j on worker w's deque unless it is queued already, a
+// busy joint is marked to be balanced again instead
+func (s *Scheduler) enqueue(w int, j int) {
+	for !atomic.CompareAndSwapInt32(&s.state[j], JOINT_IDLE, JOINT_QUEUED) {
+		switch atomic.LoadInt32(&s.state[j]) {
+		case JOINT_QUEUED, JOINT_RERUN:
+			return
+		case JOINT_BUSY:
+			if atomic.CompareAndSwapInt32(&s.state[j], JOINT_BUSY, JOINT_RERUN) {
+				return
+			}
+		}
+	}
+	atomic.AddInt64(&s.pending, 1)
+	s.deques[w].pushBack(j)
+	atomic.AddInt64(&s.available, 1)
+	if atomic.LoadInt64(&s.idle) > 0 {
+		s.mu.Lock()
+		s.wakeup.Signal()
+		s.mu.Unlock()
+	}
+}
+
+// next returns a joint from worker w's own deque, or one stolen from another
+func (s *Scheduler) next(w int) (j int, ok bool) {
+	if j, ok = s.deques[w].popFront(); !ok {
+		for k := 1; k < len(s.deques) && !ok; k++ {
+			j, ok = s.deques[(w+k)%len(s.deques)].popBack()
+		}
+	}
+	if ok {
+		atomic.AddInt64(&s.available, -1)
+	}
+	return j, ok
+}
+
+// wait blocks worker until a joint is available or the solve is done
+func (s *Scheduler) wait() (isDone bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	atomic.AddInt64(&s.idle, 1)
+	for !s.done && atomic.LoadInt64(&s.available) <= 0 {
+		s.wakeup.Wait()
+	}
+	atomic.AddInt64(&s.idle, -1)
+	return s.done
+}
+
+func (s *Scheduler) finish() {
+	atomic.StoreInt32(&s.stopped, 1)
+	s.mu.Lock()
+	s.done = true
+	s.wakeup.Broadcast()
+	s.mu.Unlock()
+}
+
+// balance holds joint j busy while worker w distributes it, and again for as
+// long as carry-overs arrive meanwhile, so that no thief takes it
+func (s *Scheduler) balance(w int, j int) {
+	atomic.StoreInt32(&s.state[j], JOINT_BUSY)
+	for {
+		s.distribute(w, j)
+		if atomic.CompareAndSwapInt32(&s.state[j], JOINT_BUSY, JOINT_IDLE) || atomic.LoadInt32(&s.stopped) != 0 {
+			return
+		}
+		atomic.StoreInt32(&s.state[j], JOINT_BUSY)
+	}
+}
+
+// distribute balances the unbalance of joint j and queues the far joints
+func (s *Scheduler) distribute(w int, j int) {
+	layout := s.layout
+	momentSum := s.unbalance(j)
+	if math.Abs(momentSum) <= TOLERANCE {
+		return
+	}
+	s.progress[w].add(1, momentSum)
+
+	messages := int64(0)
+	for i := layout.offsets[j]; i < layout.offsets[j+1]; i++ {
+		increment := -momentSum * layout.df[i]
+		atomicAddFloat64(&s.bits[i], increment)
+		if layout.cof[i] == 0 {
+			continue
+		}
+		atomicAddFloat64(&s.bits[layout.farEnd[i]], increment*layout.cof[i])
+		messages++
+		if !layout.isLocked[layout.farJoint[i]] {
+			s.enqueue(w, layout.farJoint[i])
+		}
+	}
+	if layout.telemetry != nil {
+		layout.telemetry.count(1, messages)
+	}
+}
+
+func (s *Scheduler) work(w int) {
+	for atomic.LoadInt32(&s.stopped) == 0 {
+		j, ok := s.next(w)
+		if !ok {
+			if s.wait() {
+				return
+			}
+			continue
+		}
+		s.balance(w, j)
+		if atomic.AddInt64(&s.pending, -1) == 0 {
+			s.finish()
+			return
+		}
+	}
+}
+
+func analyseStructureStealing(structure *Structure) {
+	layout := newLayout(structure)
+	exitOnDivergence(analyseLayoutStealing(layout, 4))
+	layout.writeBack()
+	fmt.Println("Work Stealing Analyse Finish")
+}
+
+func analyseLayoutStealing(layout *Layout, numWorkers int) error {
+	return runScheduler(newScheduler(layout, layout.partition(numWorkers)))
+}
+
+// runScheduler balances the queued joints and copies the moments to the layout
+func runScheduler(s *Scheduler) error {
+	layout := s.layout
+	stop := func() {}
+	if layout.telemetry != nil {
+		stop = layout.telemetry.sample(layout, atomicSnapshot(s.bits))
+	}
+	defer stop()
+	var monitor Monitor
+	check := monitor.watch(layout, s.progress, atomicSnapshot(s.bits), s.finish)
+	if s.pending == 0 {
+		return check()
+	}
+
+	var wg sync.WaitGroup
+	for w := range s.deques {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			s.work(w)
+		}(w)
+	}
+	wg.Wait()
+	if err := check(); err != nil {
+		return err
+	}
+
+	for i := range s.bits {
+		layout.moment[i] = math.Float64frombits(s.bits[i])
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// writeGrid writes a size x size grid with a fixed border and random member
+// stiffnesses and fixed end moments
+func writeGrid(t *testing.T, size int) string {
+	random := rand.New(rand.NewSource(1))
+	var input strings.Builder
+	fmt.Fprintln(&input, size*size)
+	for id := 0; id < size*size; id++ {
+		row, col := id/size, id%size
+		support := "N"
+		if row == 0 || col == 0 || row == size-1 || col == size-1 {
+			support = "F"
+		}
+		fmt.Fprintln(&input, id, support)
+	}
+	fmt.Fprintln(&input, 2*size*(size-1))
+	member := func(id1, id2 int) {
+		k, m := 0.5+random.Float64(), 100*(random.Float64()-0.5)
+		fmt.Fprintf(&input, "%d %g 0.5 %g %d %g 0.5 %g\n", id1, k, m, id2, k, -m)
+	}
+	for id := 0; id < size*size; id++ {
+		if id%size < size-1 {
+			member(id, id+1)
+		}
+		if id/size < size-1 {
+			member(id, id+size)
+		}
+	}
+
+	filename := filepath.Join(t.TempDir(), "grid.txt")
+	if err := os.WriteFile(filename, []byte(input.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestStealingBalancesEveryJoint(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	filename := writeGrid(t, 100)
+	for run := 0; run < 40; run++ {
+		structure, findings := readStructureFile(filename)
+		if structure == nil {
+			t.Fatal(findings)
+		}
+		layout := newLayout(structure)
+		if err := analyseLayoutStealing(layout, 4); err != nil {
+			t.Fatal(err)
+		}
+		if largest := largestUnbalance(layout, layout.moment); largest > TOLERANCE {
+			t.Fatalf("run %d ends with unbalance %g", run, largest)
+		}
+	}
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+//*******************SUBSTRUCTURES*****************
+
+// A joint rotation theta gives every end at the joint k theta and its far end
+// cof k theta, so the unbalance of the joints is linear in their rotations,
+// F + K theta, and balancing is solving K theta = -F. A module is a group of
+// internal joints repeated through the structure; its internal rotations are
+// eliminated once per module,
+//
+//	theta_i = -Kii^-1 (F_i + Kib theta_b)
+//
+// leaving the interface joints b with K_bb - Kbi Kii^-1 Kib, which is solved
+// by Gauss-Seidel sweeps like the moment distribution it replaces.
+
+const MODULE_TOLERANCE = 1e-9 //relative difference allowed between instances
+
+// Module is a repeated substructure, each instance lists the internal nodes of
+// one copy in the same order as the others
+type Module struct {
+	name      string
+	instances [][]int
+}
+
+// readModule reads MODULE <name> <node>..., one instance per line
+func readModule(structure *Structure, fields []string) error {
+	if len(fields) < 3 {
+		return fmt.Errorf("MODULE needs a name and its internal nodes: %v", fields)
+	}
+	nodes := make([]int, len(fields)-2)
+	for k, field := range fields[2:] {
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			return fmt.Errorf("MODULE %s: %v", fields[1], err)
+		}
+		nodes[k] = id
+	}
+	for _, module := range structure.modules {
+		if module.name == fields[1] {
+			module.instances = append(module.instances, nodes)
+			return nil
+		}
+	}
+	structure.modules = append(structure.modules, &Module{name: fields[1], instances: [][]int{nodes}})
+	return nil
+}
+
+// LU is the factorization of a square matrix with partial pivoting
+type LU struct {
+	a     [][]float64
+	pivot []int
+}
+
+func factorize(matrix [][]float64) (*LU, error) {
+	n := len(matrix)
+	lu := &LU{a: make([][]float64, n), pivot: make([]int, n)}
+	for r := range matrix {
+		lu.a[r] = append([]float64(nil), matrix[r]...)
+	}
+	for c := 0; c < n; c++ {
+		pivot := c
+		for r := c + 1; r < n; r++ {
+			if math.Abs(lu.a[r][c]) > math.Abs(lu.a[pivot][c]) {
+				pivot = r
+			}
+		}
+		if lu.a[pivot][c] == 0 {
+			return nil, fmt.Errorf("singular at column %d", c)
+		}
+		lu.pivot[c] = pivot
+		lu.a[c], lu.a[pivot] = lu.a[pivot], lu.a[c]
+		for r := c + 1; r < n; r++ {
+			lu.a[r][c] /= lu.a[c][c]
+			for k := c + 1; k < n; k++ {
+				lu.a[r][k] -= lu.a[r][c] * lu.a[c][k]
+			}
+		}
+	}
+	return lu, nil
+}
+
+func (lu *LU) solve(b []float64) []float64 {
+	x := append([]float64(nil), b...)
+	for c, pivot := range lu.pivot {
+		x[c], x[pivot] = x[pivot], x[c]
+	}
+	for r := range x {
+		for k := 0; k < r; k++ {
+			x[r] -= lu.a[r][k] * x[k]
+		}
+	}
+	for r := len(x) - 1; r >= 0; r-- {
+		for k := r + 1; k < len(x); k++ {
+			x[r] -= lu.a[r][k] * x[k]
+		}
+		x[r] /= lu.a[r][r]
+	}
+	return x
+}
+
+// Instance is one copy of a module with the coupling of its internal joints
+// to each other and to its interface joints
+type Instance struct {
+	internal  []int
+	boundary  []int //interface nodes in order of first reach
+	kii       [][]float64
+	kib       [][]float64
+	kbi       [][]float64
+	unbalance []float64 //F_i
+}
+
+// coupling is how much the unbalance at node id changes per unit rotation of
+// each node, the diagonal and the far ends of its members
+func (structure *Structure) coupling(id int) map[int]float64 {
+	node := structure.nodeMap[id]
+	row := make(map[int]float64)
+	for k := 0; k < len(node.ends); k++ {
+		end := node.ends[k]
+		row[id] += end.stiffness
+		if end.member < 0 || structure.nodeMap[end.otherEndNodeID].isLocked() {
+			continue
+		}
+		far := structure.nodeMap[end.otherEndNodeID].ends[end.otherEndIndex]
+		row[end.otherEndNodeID] += far.cof * far.stiffness
+	}
+	return row
+}
+
+func (structure *Structure) unbalanceAt(id int) (sum float64) {
+	for _, end := range structure.nodeMap[id].ends {
+		sum += end.fem
+	}
+	return sum
+}
+
+// newInstance finds the interface of the internal nodes, owner maps every
+// internal node of every instance to its instance
+func (structure *Structure) newInstance(internal []int, owner map[int]*Instance) (*Instance, error) {
+	instance := &Instance{internal: internal}
+	index := make(map[int]int)
+	for k, id := range internal {
+		index[id] = k
+	}
+	boundary := make(map[int]int)
+	for _, id := range internal {
+		node := structure.nodeMap[id]
+		for k := 0; k < len(node.ends); k++ {
+			far := node.ends[k].otherEndNodeID
+			if _, ok := index[far]; ok || structure.nodeMap[far].isLocked() {
+				continue
+			}
+			if _, ok := owner[far]; ok {
+				return nil, fmt.Errorf("node %d touches node %d of another instance, instances need interface nodes between them", id, far)
+			}
+			if _, ok := boundary[far]; !ok {
+				boundary[far] = len(instance.boundary)
+				instance.boundary = append(instance.boundary, far)
+			}
+		}
+	}
+
+	n, m := len(internal), len(instance.boundary)
+	instance.kii = make([][]float64, n)
+	instance.kib = make([][]float64, n)
+	instance.kbi = make([][]float64, m)
+	for b := range instance.kbi {
+		instance.kbi[b] = make([]float64, n)
+	}
+	instance.unbalance = make([]float64, n)
+	for k, id := range internal {
+		instance.kii[k] = make([]float64, n)
+		instance.kib[k] = make([]float64, m)
+		for col, value := range structure.coupling(id) {
+			if c, ok := index[col]; ok {
+				instance.kii[k][c] += value
+			} else if b, ok := boundary[col]; ok {
+				instance.kib[k][b] += value
+			}
+		}
+		instance.unbalance[k] = structure.unbalanceAt(id)
+	}
+	for b, id := range instance.boundary {
+		for col, value := range structure.coupling(id) {
+			if c, ok := index[col]; ok {
+				instance.kbi[b][c] += value
+			}
+		}
+	}
+	return instance, nil
+}
+
+// sameMatrix compares the stiffness of an instance with the first one
+func sameMatrix(a, b [][]float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for r := range a {
+		if len(a[r]) != len(b[r]) {
+			return false
+		}
+		for c := range a[r] {
+			if math.Abs(a[r][c]-b[r][c]) > MODULE_TOLERANCE*(math.Abs(a[r][c])+math.Abs(b[r][c])) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Condensed is a module eliminated down to its interface, shared by all of
+// its instances: X = Kii^-1 Kib and the Schur term Kbi X
+type Condensed struct {
+	lu    *LU
+	x     [][]float64 //internal x interface
+	schur [][]float64 //interface x interface
+}
+
+func condense(first *Instance) (*Condensed, error) {
+	lu, err := factorize(first.kii)
+	if err != nil {
+		return nil, err
+	}
+	n, m := len(first.internal), len(first.boundary)
+	condensed := &Condensed{lu: lu, x: make([][]float64, n), schur: make([][]float64, m)}
+	for k := range condensed.x {
+		condensed.x[k] = make([]float64, m)
+	}
+	column := make([]float64, n)
+	for b := 0; b < m; b++ {
+		for k := range column {
+			column[k] = first.kib[k][b]
+		}
+		for k, value := range lu.solve(column) {
+			condensed.x[k][b] = value
+		}
+	}
+	for b := range condensed.schur {
+		condensed.schur[b] = make([]float64, m)
+		for k := 0; k < n; k++ {
+			if first.kbi[b][k] == 0 {
+				continue
+			}
+			for c := 0; c < m; c++ {
+				condensed.schur[b][c] += first.kbi[b][k] * condensed.x[k][c]
+			}
+		}
+	}
+	return condensed, nil
+}
+
+// CondensedInstance is an instance with its interface indices and y = Kii^-1 F_i
+type CondensedInstance struct {
+	instance  *Instance
+	condensed *Condensed
+	boundary  []int
+	y         []float64
+}
+
+// ModuleTerm is the Schur row b of an instance that touches an interface joint
+type ModuleTerm struct {
+	instance *CondensedInstance
+	b        int
+}
+
+// InterfaceRow is the equation of one interface joint
+type InterfaceRow struct {
+	cols     []int
+	values   []float64
+	modules  []ModuleTerm
+	diagonal float64
+	rhs      float64
+}
+
+// residual is the unbalance left at the joint, -F* - K* theta
+func (row *InterfaceRow) residual(theta []float64) float64 {
+	residual := row.rhs
+	for k, c := range row.cols {
+		residual -= row.values[k] * theta[c]
+	}
+	for _, term := range row.modules {
+		schur := term.instance.condensed.schur[term.b]
+		for c, g := range term.instance.boundary {
+			residual += schur[c] * theta[g]
+		}
+	}
+	return residual
+}
+
+// solveCondensed sets the end moments of the structure from the fixed end
+// moments by condensing every module, solving the interface and recovering
+// the internal rotations. It returns the Gauss-Seidel sweeps of the interface.
+func solveCondensed(structure *Structure) (sweeps int, err error) {
+	owner := make(map[int]*Instance)
+	for _, module := range structure.modules {
+		for _, internal := range module.instances {
+			for _, id := range internal {
+				node, ok := structure.nodeMap[id]
+				switch {
+				case !ok:
+					return 0, fmt.Errorf("module %s: no node %d", module.name, id)
+				case node.isLocked():
+					return 0, fmt.Errorf("module %s: node %d is held and cannot be internal", module.name, id)
+				case owner[id] != nil:
+					return 0, fmt.Errorf("module %s: node %d is in two instances", module.name, id)
+				}
+				owner[id] = &Instance{internal: internal}
+			}
+		}
+	}
+
+	//the interface problem: every free node outside the modules, the direct
+	//coupling in rows and that through the modules in their Schur terms
+	var interfaceIDs []int
+	for id, node := range structure.nodeMap {
+		if !node.isLocked() && owner[id] == nil {
+			interfaceIDs = append(interfaceIDs, id)
+		}
+	}
+	sort.Ints(interfaceIDs)
+	index := make(map[int]int, len(interfaceIDs))
+	for g, id := range interfaceIDs {
+		index[id] = g
+	}
+	rows := make([]InterfaceRow, len(interfaceIDs))
+	for g, id := range interfaceIDs {
+		row := &rows[g]
+		for col, value := range structure.coupling(id) {
+			if c, ok := index[col]; ok {
+				row.cols = append(row.cols, c)
+				row.values = append(row.values, value)
+				if c == g {
+					row.diagonal += value
+				}
+			}
+		}
+		row.rhs = -structure.unbalanceAt(id)
+	}
+
+	var instances []*CondensedInstance
+	for _, module := range structure.modules {
+		var first *Instance
+		var condensed *Condensed
+		for k, internal := range module.instances {
+			instance, err := structure.newInstance(internal, owner)
+			if err != nil {
+				return 0, fmt.Errorf("module %s: %v", module.name, err)
+			}
+			for _, id := range internal {
+				owner[id] = instance
+			}
+			if first == nil {
+				first = instance
+				if condensed, err = condense(first); err != nil {
+					return 0, fmt.Errorf("module %s: %v", module.name, err)
+				}
+			} else if !sameMatrix(instance.kii, first.kii) || !sameMatrix(instance.kib, first.kib) || !sameMatrix(instance.kbi, first.kbi) {
+				return 0, fmt.Errorf("module %s: instance %d differs from the first", module.name, k+1)
+			}
+
+			//F_b* = F_b - Kbi y, K_bb* = K_bb - Kbi X
+			c := &CondensedInstance{instance: instance, condensed: condensed, y: condensed.lu.solve(instance.unbalance)}
+			for b, id := range instance.boundary {
+				g := index[id]
+				c.boundary = append(c.boundary, g)
+				rows[g].modules = append(rows[g].modules, ModuleTerm{c, b})
+				rows[g].diagonal -= condensed.schur[b][b]
+				for k, value := range c.y {
+					rows[g].rhs += instance.kbi[b][k] * value
+				}
+			}
+			instances = append(instances, c)
+		}
+	}
+
+	//Gauss-Seidel on the interface, stopping at the same unbalance as the solvers
+	theta := make([]float64, len(interfaceIDs))
+	var monitor Monitor
+	for isFinish := len(rows) == 0; !isFinish; {
+		sweeps++
+		isFinish = true
+		largest := float64(0)
+		for g := range rows {
+			residual := rows[g].residual(theta)
+			largest = math.Max(largest, math.Abs(residual))
+			if math.Abs(residual) > TOLERANCE {
+				isFinish = false
+				theta[g] += residual / rows[g].diagonal
+			}
+		}
+		if reason := monitor.check(largest); reason != "" {
+			return sweeps, fmt.Errorf("no convergence of the interface at sweep %d, %s", sweeps, reason)
+		}
+	}
+
+	//theta_i = -y - X theta_b
+	rotation := make(map[int]float64, len(structure.nodeMap))
+	for g, id := range interfaceIDs {
+		rotation[id] = theta[g]
+	}
+	for _, c := range instances {
+		for k, id := range c.instance.internal {
+			rotation[id] = -c.y[k]
+			for b, g := range c.boundary {
+				rotation[id] -= c.condensed.x[k][b] * theta[g]
+			}
+		}
+	}
+
+	for id, node := range structure.nodeMap {
+		for _, end := range node.ends {
+			end.moment = end.fem + end.stiffness*rotation[id]
+			if end.member >= 0 {
+				far := structure.nodeMap[end.otherEndNodeID].ends[end.otherEndIndex]
+				end.moment += far.cof * far.stiffness * rotation[end.otherEndNodeID]
+			}
+			if !isFinite(end.moment) {
+				return sweeps, fmt.Errorf("the moment %g at node %d is not finite", end.moment, id)
+			}
+		}
+	}
+	return sweeps, nil
+}
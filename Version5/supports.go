@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//*******************SUPPORTS**********************
+
+// Support is the restraint of a node, read from the Fix/Non-Fix column
+type Support int
+
+const (
+	SUPPORT_JOINT  Support = iota //N: free to rotate
+	SUPPORT_FIXED                 //F: no rotation, no translation
+	SUPPORT_PINNED                //P: no translation, free to rotate
+	SUPPORT_ROLLER                //R: free to rotate and to roll along x
+	SUPPORT_GUIDED                //G: no rotation, free to slide along y
+	SUPPORT_SPRING                //S:k: rotational spring of stiffness k
+	SUPPORT_FREE                  //T: free tip of a cantilever
+)
+
+const DEFAULT_CARRYOVER = 0.5
+
+var supportNames = map[Support]string{
+	SUPPORT_JOINT:  "Non-fix",
+	SUPPORT_FIXED:  "Fix",
+	SUPPORT_PINNED: "Pinned",
+	SUPPORT_ROLLER: "Roller",
+	SUPPORT_GUIDED: "Guided",
+	SUPPORT_SPRING: "Spring",
+	SUPPORT_FREE:   "Free",
+}
+
+func (support Support) String() string {
+	return supportNames[support]
+}
+
+// parseSupport reads F, N, P, R, G, T or S:<k>
+func parseSupport(token string) (support Support, springStiffness float64, err error) {
+	switch {
+	case token == "F":
+		return SUPPORT_FIXED, 0, nil
+	case token == "N":
+		return SUPPORT_JOINT, 0, nil
+	case token == "P":
+		return SUPPORT_PINNED, 0, nil
+	case token == "R":
+		return SUPPORT_ROLLER, 0, nil
+	case token == "G":
+		return SUPPORT_GUIDED, 0, nil
+	case token == "T":
+		return SUPPORT_FREE, 0, nil
+	case strings.HasPrefix(token, "S:"):
+		springStiffness, err = strconv.ParseFloat(token[2:], 64)
+		return SUPPORT_SPRING, springStiffness, err
+	}
+	return SUPPORT_JOINT, 0, fmt.Errorf("unknown support %q", token)
+}
+
+// numMemberEnds counts the ends of a node that belong to members, leaving out
+// the grounded end of a rotational spring
+func (node Node) numMemberEnds() (count int) {
+	for _, end := range node.ends {
+		if end.member >= 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// isReleased tells whether the node is an end support that carries no moment:
+// a pin or roller at the end of a single member, or a free cantilever tip
+func (node Node) isReleased() bool {
+	switch node.support {
+	case SUPPORT_PINNED, SUPPORT_ROLLER, SUPPORT_FREE:
+		return node.numMemberEnds() == 1
+	}
+	return false
+}
+
+// isLocked tells whether the solvers leave the node alone: it cannot rotate,
+// or it is released and its moment is already zero
+func (node Node) isLocked() bool {
+	return node.isFixed || node.support == SUPPORT_GUIDED || node.isReleased()
+}
+
+// applySupports adjusts the members for the support at their far end before
+// the distribution factors are normalized. For an end at joint A whose far
+// node B is
+//
+//	a released pin or roller: stiffness 3/4 k, no carry-over, B is released once
+//	guided:                   stiffness 1/4 k, carry-over -1
+//	a free cantilever tip:    stiffness 0, no carry-over, the moment at A is the
+//	                          cantilever moment as given
+//
+// and a rotational spring adds a grounded end of stiffness k to its node. The
+// moments are released by releaseMoments.
+func applySupports(structure *Structure) {
+	for id, node := range structure.nodeMap {
+		if node.support == SUPPORT_SPRING && node.springStiffness > 0 {
+			spring := new(End)
+			spring.df = node.springStiffness
+			spring.member = -1
+			spring.side = -1
+			spring.otherEndNodeID = id
+			spring.otherEndIndex = node.addEnd(spring)
+		}
+	}
+
+	for _, member := range structure.members() {
+		node1 := structure.nodeMap[member.node1]
+		node2 := structure.nodeMap[member.node2]
+		adjustForFarSupport(member.end1, member.end2, node2)
+		adjustForFarSupport(member.end2, member.end1, node1)
+	}
+}
+
+// adjustForFarSupport sets stiffness and carry-over of the near end for the
+// support of the far node
+func adjustForFarSupport(near, far *End, farNode Node) {
+	if near.released || far.released {
+		//already set by applyReleases
+		return
+	}
+	if !farNode.isReleased() || farNode.isFixed {
+		if farNode.support == SUPPORT_GUIDED {
+			near.df *= 0.25
+			near.cof = -1
+		}
+		return
+	}
+
+	far.cof = 0
+	near.cof = 0
+	switch farNode.support {
+	case SUPPORT_FREE:
+		near.df = 0
+	default:
+		near.df *= 0.75
+	}
+}
+
+// restrainedAxes tells along which of x, y and z a node cannot translate. A
+// plain joint is a support when its members are collinear and a free frame
+// joint when they meet at an angle.
+func (structure *Structure) restrainedAxes(node Node) [3]bool {
+	switch node.support {
+	case SUPPORT_FIXED, SUPPORT_PINNED, SUPPORT_SPRING:
+		return [3]bool{true, true, true}
+	case SUPPORT_ROLLER:
+		return [3]bool{false, true, true}
+	case SUPPORT_GUIDED:
+		return [3]bool{true, false, true}
+	case SUPPORT_FREE:
+		return [3]bool{false, false, false}
+	}
+	restrained := structure.translationRestrained(node)
+	return [3]bool{restrained, restrained, restrained}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+//******************DIAGRAMS************************
+
+const (
+	SVG_WIDTH       = 1000.0
+	SVG_PANEL       = 460.0 //height of the moment and of the shear panel
+	SVG_MARGIN      = 60.0
+	SVG_DIAGRAM_MAX = 40.0 //largest diagram ordinate in pixels
+)
+
+type Point struct {
+	x, y float64
+}
+
+// nodePositions places the nodes for drawing. Node coordinates are used when
+// every node has them (x-z for a model in that plane), otherwise a chain of
+// members is laid out on a line at unit spacing and anything else on a circle.
+func nodePositions(structure *Structure) map[int]Point {
+	ids := make([]int, 0, len(structure.nodeMap))
+	for id := range structure.nodeMap {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	positions := make(map[int]Point, len(ids))
+	if len(ids) == 0 {
+		return positions
+	}
+
+	if structure.hasCoords() {
+		useZ := true
+		for _, node := range structure.nodeMap {
+			if node.y != 0 {
+				useZ = false
+			}
+		}
+		for id, node := range structure.nodeMap {
+			if useZ {
+				positions[id] = Point{node.x, node.z}
+			} else {
+				positions[id] = Point{node.x, node.y}
+			}
+		}
+		return positions
+	}
+
+	if chain := chainOrder(structure, ids); chain != nil {
+		for k, id := range chain {
+			positions[id] = Point{float64(k), 0}
+		}
+		return positions
+	}
+
+	radius := float64(len(ids)) / (2 * math.Pi)
+	for k, id := range ids {
+		angle := 2 * math.Pi * float64(k) / float64(len(ids))
+		positions[id] = Point{radius * math.Cos(angle), radius * math.Sin(angle)}
+	}
+	return positions
+}
+
+// chainOrder returns the nodes from one end of the chain to the other, or nil
+// when the structure is not a single chain of members
+func chainOrder(structure *Structure, ids []int) []int {
+	start := -1
+	for _, id := range ids {
+		switch structure.nodeMap[id].numMemberEnds() {
+		case 1:
+			if start < 0 {
+				start = id
+			}
+		case 2:
+		default:
+			return nil
+		}
+	}
+	if start < 0 {
+		return nil
+	}
+
+	chain := []int{start}
+	previous, current := -1, start
+	for len(chain) < len(ids) {
+		next := -1
+		for _, end := range structure.nodeMap[current].ends {
+			if end.member >= 0 && end.otherEndNodeID != previous && end.otherEndNodeID != current {
+				next = end.otherEndNodeID
+				break
+			}
+		}
+		if next < 0 {
+			return nil
+		}
+		chain = append(chain, next)
+		previous, current = current, next
+	}
+	return chain
+}
+
+// memberDiagram holds the end moments of a member in the input direction,
+// node1 to node2. The structure keeps only the fixed end moments, not the
+// loads behind them, so the diagrams are those of the end moments alone: with
+// clockwise positive end moments the sagging moment is linear, M(t) =
+// m1 (1 - t) - m2 t, and the shear is constant, V = -(m1 + m2) / L. The free
+// moment and shear of a loaded span are not added.
+type memberDiagram struct {
+	member    *Member
+	p1, p2    Point
+	length    float64
+	m1, m2    float64
+	shear     float64
+	endMoment float64 //larger sagging end moment, the one labelled
+}
+
+func newMemberDiagram(member *Member, positions map[int]Point) *memberDiagram {
+	diagram := new(memberDiagram)
+	diagram.member = member
+	diagram.p1 = positions[member.node1]
+	diagram.p2 = positions[member.node2]
+	diagram.length = math.Hypot(diagram.p2.x-diagram.p1.x, diagram.p2.y-diagram.p1.y)
+	diagram.m1 = member.end1.moment
+	diagram.m2 = member.end2.moment
+	if diagram.length > 0 {
+		diagram.shear = -(diagram.m1 + diagram.m2) / diagram.length
+	}
+
+	//sagging moment at the ends, a span load may give a larger one in the span
+	diagram.endMoment = diagram.m1
+	if math.Abs(-diagram.m2) > math.Abs(diagram.m1) {
+		diagram.endMoment = -diagram.m2
+	}
+	return diagram
+}
+
+// svgCanvas maps model coordinates into one panel of the drawing
+type svgCanvas struct {
+	w                 *bufio.Writer
+	minX, maxY, scale float64
+	offsetX, offsetY  float64
+}
+
+func (canvas *svgCanvas) at(p Point) (x, y float64) {
+	return canvas.offsetX + (p.x-canvas.minX)*canvas.scale, canvas.offsetY + (canvas.maxY-p.y)*canvas.scale
+}
+
+// writeDiagramSVG draws the bending moment panel above the shear panel, both
+// from the end moments only
+func writeDiagramSVG(w io.Writer, structure *Structure) error {
+	positions := nodePositions(structure)
+	diagrams := []*memberDiagram{}
+	maxMoment, maxShear := float64(0), float64(0)
+	for _, member := range structure.members() {
+		diagram := newMemberDiagram(member, positions)
+		diagrams = append(diagrams, diagram)
+		maxMoment = math.Max(maxMoment, math.Max(math.Abs(diagram.m1), math.Abs(diagram.m2)))
+		maxShear = math.Max(maxShear, math.Abs(diagram.shear))
+	}
+
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range positions {
+		minX, maxX = math.Min(minX, p.x), math.Max(maxX, p.x)
+		minY, maxY = math.Min(minY, p.y), math.Max(maxY, p.y)
+	}
+	if len(positions) == 0 {
+		minX, maxX, minY, maxY = 0, 1, 0, 1
+	}
+
+	inner := SVG_WIDTH - 2*SVG_MARGIN
+	innerHeight := SVG_PANEL - 2*SVG_MARGIN
+	scale := inner / math.Max(maxX-minX, 1e-9)
+	if maxY > minY {
+		scale = math.Min(scale, innerHeight/(maxY-minY))
+	}
+	height := 2 * SVG_PANEL
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f" font-family="sans-serif" font-size="11">`+"\n",
+		SVG_WIDTH, height, SVG_WIDTH, height)
+	fmt.Fprintf(out, `<rect width="100%%" height="100%%" fill="white"/>`+"\n")
+
+	panels := []struct {
+		title string
+		shear bool
+		max   float64
+	}{{"Bending moment from the end moments only, span loads not drawn (sagging positive, on the tension side)", false, maxMoment},
+		{"Shear from the end moments only, span loads not drawn", true, maxShear}}
+	for k, panel := range panels {
+		canvas := &svgCanvas{out, minX, maxY, scale, SVG_MARGIN, float64(k)*SVG_PANEL + SVG_MARGIN}
+		if maxY == minY {
+			canvas.offsetY += innerHeight / 2
+		}
+		fmt.Fprintf(out, `<text x="%.1f" y="%.1f" font-size="14">%s</text>`+"\n", SVG_MARGIN, float64(k)*SVG_PANEL+24, panel.title)
+
+		for _, diagram := range diagrams {
+			canvas.drawMember(diagram, panel.shear, panel.max)
+		}
+		for id, p := range positions {
+			canvas.drawSupport(p, structure.nodeMap[id])
+		}
+	}
+	fmt.Fprintln(out, "</svg>")
+	return out.Flush()
+}
+
+func (canvas *svgCanvas) drawMember(diagram *memberDiagram, isShear bool, max float64) {
+	x1, y1 := canvas.at(diagram.p1)
+	x2, y2 := canvas.at(diagram.p2)
+	fmt.Fprintf(canvas.w, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="black" stroke-width="2"/>`+"\n", x1, y1, x2, y2)
+	if diagram.length == 0 || max == 0 {
+		return
+	}
+
+	//unit normal on screen, pointing to the right of node1 -> node2 (down for a
+	//left to right member), sagging moment is drawn on that side
+	dx, dy := (x2-x1)/math.Hypot(x2-x1, y2-y1), (y2-y1)/math.Hypot(x2-x1, y2-y1)
+	nx, ny := -dy, dx
+
+	value1, value2, color := diagram.m1, -diagram.m2, "#3060c0"
+	if isShear {
+		//positive shear is drawn on the other side
+		value1, value2, color = -diagram.shear, -diagram.shear, "#c05030"
+	}
+	o1 := value1 / max * SVG_DIAGRAM_MAX
+	o2 := value2 / max * SVG_DIAGRAM_MAX
+	fmt.Fprintf(canvas.w, `<polygon points="%.1f,%.1f %.1f,%.1f %.1f,%.1f %.1f,%.1f" fill="%s" fill-opacity="0.35" stroke="%s"/>`+"\n",
+		x1, y1, x1+nx*o1, y1+ny*o1, x2+nx*o2, y2+ny*o2, x2, y2, color, color)
+
+	//label the larger end moment, or the shear of the end moments
+	if isShear {
+		mx, my := (x1+x2)/2+nx*o1, (y1+y2)/2+ny*o1
+		fmt.Fprintf(canvas.w, `<text x="%.1f" y="%.1f" fill="%s" text-anchor="middle">%.1f</text>`+"\n", mx, my-4, color, diagram.shear)
+		return
+	}
+	px, py, offset := x1, y1, o1
+	if diagram.endMoment != diagram.m1 {
+		px, py, offset = x2, y2, o2
+	}
+	fmt.Fprintf(canvas.w, `<text x="%.1f" y="%.1f" fill="%s" text-anchor="middle">%.1f</text>`+"\n",
+		px+nx*(offset+8*sign(offset)), py+ny*(offset+8*sign(offset))+4, color, diagram.endMoment)
+}
+
+// drawSupport draws the support symbol of a node: a hatched block when fixed,
+// a triangle for a pin, a triangle on a line for a roller, two bars for a
+// guide, a coil for a rotational spring, and a dot at joints and free tips
+func (canvas *svgCanvas) drawSupport(p Point, node Node) {
+	x, y := canvas.at(p)
+	w := canvas.w
+	switch node.support {
+	case SUPPORT_FIXED:
+		fmt.Fprintf(w, `<rect x="%.1f" y="%.1f" width="16" height="16" fill="none" stroke="black"/>`+"\n", x-8, y-8)
+		for k := 0.0; k < 16; k += 4 {
+			fmt.Fprintf(w, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="black"/>`+"\n", x-8+k, y+8, x-4+k, y-8)
+		}
+	case SUPPORT_PINNED, SUPPORT_ROLLER:
+		fmt.Fprintf(w, `<polygon points="%.1f,%.1f %.1f,%.1f %.1f,%.1f" fill="none" stroke="black"/>`+"\n", x, y, x-8, y+12, x+8, y+12)
+		if node.support == SUPPORT_ROLLER {
+			fmt.Fprintf(w, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="black"/>`+"\n", x-10, y+16, x+10, y+16)
+		}
+	case SUPPORT_GUIDED:
+		fmt.Fprintf(w, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="black"/>`+"\n", x-6, y-10, x-6, y+10)
+		fmt.Fprintf(w, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="black"/>`+"\n", x+6, y-10, x+6, y+10)
+	case SUPPORT_SPRING:
+		fmt.Fprintf(w, `<path d="M %.1f %.1f a 6 6 0 1 1 -6 -6 a 9 9 0 1 1 -9 9" fill="none" stroke="black"/>`+"\n", x, y)
+	}
+	fmt.Fprintf(w, `<circle cx="%.1f" cy="%.1f" r="3" fill="black"/>`+"\n", x, y)
+	fmt.Fprintf(w, `<text x="%.1f" y="%.1f" text-anchor="middle" fill="#666">%d</text>`+"\n", x, y+28, node.id)
+}
+
+func sign(value float64) float64 {
+	if value < 0 {
+		return -1
+	}
+	return 1
+}
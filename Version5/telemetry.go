@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//*******************TELEMETRY*********************
+
+// SweepStats is the convergence of one sweep of the sequential solver, or of
+// one time slice of a parallel solver: the largest and the L2 norm of the
+// joint unbalances at its end, and the carry-overs sent and joints balanced
+// during it
+type SweepStats struct {
+	Sweep       int
+	Elapsed     time.Duration
+	MaxResidual float64
+	L2Residual  float64
+	Messages    int64
+	Balanced    int64
+}
+
+// Observer is told the stats of every sweep or time slice
+type Observer interface {
+	observe(stats SweepStats)
+}
+
+// Telemetry counts for a solve and reports to its observer. The counters are
+// added to by the workers and taken by each report.
+type Telemetry struct {
+	observer Observer
+	slice    time.Duration //time slice of the parallel solvers
+	start    time.Time
+	sweep    int
+	messages int64
+	balanced int64
+}
+
+func newTelemetry(observer Observer, slice time.Duration) *Telemetry {
+	return &Telemetry{observer: observer, slice: slice, start: time.Now()}
+}
+
+// count adds the joints a worker balanced and the carry-overs it sent
+func (telemetry *Telemetry) count(balanced, messages int64) {
+	atomic.AddInt64(&telemetry.balanced, balanced)
+	atomic.AddInt64(&telemetry.messages, messages)
+}
+
+// report measures the unbalance of every joint that is balanced by the solver
+func (telemetry *Telemetry) report(layout *Layout, moment []float64) {
+	stats := SweepStats{Sweep: telemetry.sweep, Elapsed: time.Since(telemetry.start)}
+	telemetry.sweep++
+	stats.Messages = atomic.SwapInt64(&telemetry.messages, 0)
+	stats.Balanced = atomic.SwapInt64(&telemetry.balanced, 0)
+
+	sumSquare := float64(0)
+	for j := 0; j < layout.numJoints(); j++ {
+		if layout.isLocked[j] {
+			continue
+		}
+		momentSum := float64(0)
+		for i := layout.offsets[j]; i < layout.offsets[j+1]; i++ {
+			momentSum += moment[i]
+		}
+		stats.MaxResidual = math.Max(stats.MaxResidual, math.Abs(momentSum))
+		sumSquare += momentSum * momentSum
+	}
+	stats.L2Residual = math.Sqrt(sumSquare)
+	telemetry.observer.observe(stats)
+}
+
+// sample reports every time slice from a snapshot of the moments of a
+// parallel solver; the returned stop ends it with a report of the result
+func (telemetry *Telemetry) sample(layout *Layout, snapshot func() []float64) (stop func()) {
+	done := make(chan bool)
+	stopped := make(chan bool)
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(telemetry.slice)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				telemetry.report(layout, snapshot())
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		telemetry.report(layout, snapshot())
+	}
+}
+
+// atomicSnapshot reads the moments of the solvers that keep them as bits
+func atomicSnapshot(bits []uint64) func() []float64 {
+	return func() []float64 {
+		moment := make([]float64, len(bits))
+		for i := range bits {
+			moment[i] = atomicLoadFloat64(&bits[i])
+		}
+		return moment
+	}
+}
+
+// mailboxSnapshot reads the moments of the channel solver between rounds, with
+// the carry-overs still in the mailboxes added to their ends
+func mailboxSnapshot(layout *Layout, mailboxes []Mailbox, gate *sync.RWMutex) func() []float64 {
+	return func() []float64 {
+		gate.Lock()
+		defer gate.Unlock()
+		moment := append([]float64(nil), layout.moment...)
+		for w := range mailboxes {
+			mailbox := &mailboxes[w]
+			mailbox.mu.Lock()
+			for _, update := range mailbox.updates {
+				moment[update.endIndex] += update.carryover
+			}
+			mailbox.mu.Unlock()
+		}
+		return moment
+	}
+}
+
+// TelemetryLog keeps every report for writing as CSV
+type TelemetryLog struct {
+	solver string
+	stats  []SweepStats
+}
+
+func (log *TelemetryLog) observe(stats SweepStats) {
+	log.stats = append(log.stats, stats)
+}
+
+func (log *TelemetryLog) writeCSV(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "solver,sweep,elapsed_ms,max_residual,l2_residual,messages,balanced")
+	for _, stats := range log.stats {
+		fmt.Fprintf(out, "%s,%d,%.3f,%g,%g,%d,%d\n", log.solver, stats.Sweep,
+			float64(stats.Elapsed.Microseconds())/1000, stats.MaxResidual, stats.L2Residual, stats.Messages, stats.Balanced)
+	}
+	return out.Flush()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+//*******************THERMAL AND MISFIT************
+
+const (
+	THERMAL_CASE = "thermal"
+	MISFIT_CASE  = "misfit"
+)
+
+// Misfit is a clockwise rotation forced on the ends of a member to fit it
+type Misfit struct {
+	member   int
+	rotation [2]float64
+	loadCase *LoadCase
+}
+
+// readMemberValues reads <keyword> <member> <value>... [case] with numValues
+// values, the case defaults to name
+func readMemberValues(structure *Structure, fields []string, numValues int, name string) (member *Member, values []float64, loadCase *LoadCase, err error) {
+	if len(fields) < 2+numValues || len(fields) > 3+numValues {
+		return nil, nil, nil, fmt.Errorf("%s needs a member and %d values: %v", fields[0], numValues, fields)
+	}
+	id, err := strconv.Atoi(fields[1])
+	if err != nil || id < 0 || id >= len(structure.memberList) {
+		return nil, nil, nil, fmt.Errorf("%s: no member %q", fields[0], fields[1])
+	}
+	for _, field := range fields[2 : 2+numValues] {
+		value, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%s: %v", fields[0], err)
+		}
+		values = append(values, value)
+	}
+	if len(fields) == 3+numValues {
+		name = fields[2+numValues]
+	}
+	return structure.memberList[id], values, structure.loadCase(name), nil
+}
+
+// readThermal reads THERMAL <member> <dT> <alpha> <depth> [case]. The member
+// wants to curve by alpha dT / depth, held at both ends that gives the
+// constant sagging moment EI alpha dT / depth: +M at end1 and -M at end2.
+func readThermal(structure *Structure, fields []string) error {
+	member, values, loadCase, err := readMemberValues(structure, fields, 3, THERMAL_CASE)
+	if err != nil {
+		return err
+	}
+	dT, alpha, depth := values[0], values[1], values[2]
+	if member.end1.ei <= 0 || depth <= 0 {
+		return fmt.Errorf("THERMAL: member %d needs EI and a depth", member.id)
+	}
+	moment := member.end1.ei * alpha * dT / depth
+	loadCase.moments[member.id][0] += moment
+	loadCase.moments[member.id][1] -= moment
+	return nil
+}
+
+// readMisfit reads MISFIT <member> <theta1> <theta2> [case]
+func readMisfit(structure *Structure, fields []string) error {
+	member, values, loadCase, err := readMemberValues(structure, fields, 2, MISFIT_CASE)
+	if err != nil {
+		return err
+	}
+	structure.misfits = append(structure.misfits, Misfit{member.id, [2]float64{values[0], values[1]}, loadCase})
+	return nil
+}
+
+// applyMisfits adds the fixed end moments of the misfits to their cases, like
+// a rotation of a held node: k theta at the end and half of it at the other,
+// with the member stiffness k = 4EI/L before releases and supports change it
+func applyMisfits(structure *Structure) error {
+	for _, misfit := range structure.misfits {
+		member := structure.memberList[misfit.member]
+		if !structure.hasBeamStiffness(member) {
+			return fmt.Errorf("MISFIT: member %d needs EI and coordinates", member.id)
+		}
+		moments := &misfit.loadCase.moments[member.id]
+		k := [2]float64{member.end1.df, member.end2.df}
+		for near := 0; near < 2; near++ {
+			moments[near] += k[near] * misfit.rotation[near]
+			moments[1-near] += k[near] * misfit.rotation[near] * DEFAULT_CARRYOVER
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+)
+
+//*******************TRACE*************************
+
+// Trace records every balancing step of the sequential solver
+type Trace struct {
+	fem   []float64 //moments the solve started from
+	steps []TraceStep
+}
+
+// TraceStep is one joint balanced in one sweep: the unbalance it had, what
+// each of its ends took and what each carried to its far end
+type TraceStep struct {
+	iteration  int
+	joint      int
+	unbalance  float64
+	balances   []float64
+	carryovers []float64
+}
+
+func newTrace(layout *Layout) *Trace {
+	return &Trace{fem: append([]float64(nil), layout.moment...)}
+}
+
+func (trace *Trace) record(layout *Layout, iteration int, j int, unbalance float64) {
+	step := TraceStep{iteration: iteration, joint: j, unbalance: unbalance}
+	for i := layout.offsets[j]; i < layout.offsets[j+1]; i++ {
+		balance := -unbalance * layout.df[i]
+		step.balances = append(step.balances, balance)
+		step.carryovers = append(step.carryovers, balance*layout.cof[i])
+	}
+	trace.steps = append(trace.steps, step)
+}
+
+// TableRow is one row of a Hardy Cross table, one value per end
+type TableRow struct {
+	label  string
+	values []float64
+	isStep bool //Bal and CO rows leave zeros blank
+}
+
+// HardyCrossTable has a column per end, grouped by joint: the joint, the far
+// node of the member, then DF and FEM, a Bal and a CO row per sweep and the
+// final moments
+type HardyCrossTable struct {
+	joints []string
+	ends   []string
+	rows   []TableRow
+}
+
+func (trace *Trace) table(layout *Layout) *HardyCrossTable {
+	table := new(HardyCrossTable)
+	for j := 0; j < layout.numJoints(); j++ {
+		for i := layout.offsets[j]; i < layout.offsets[j+1]; i++ {
+			table.joints = append(table.joints, strconv.Itoa(layout.nodeIDs[j]))
+			end := layout.ends[i]
+			if end.member < 0 {
+				table.ends = append(table.ends, "spring")
+			} else {
+				table.ends = append(table.ends, fmt.Sprintf("m%d to %d", end.member, end.otherEndNodeID))
+			}
+		}
+	}
+
+	numEnds := layout.numEnds()
+	df := make([]float64, numEnds)
+	for i := range df {
+		if !layout.isLocked[jointOf(layout, i)] {
+			df[i] = layout.df[i]
+		}
+	}
+	table.rows = append(table.rows, TableRow{"DF", df, false}, TableRow{"FEM", trace.fem, false})
+
+	var balance, carryover []float64
+	for k, step := range trace.steps {
+		if k == 0 || step.iteration != trace.steps[k-1].iteration {
+			balance, carryover = make([]float64, numEnds), make([]float64, numEnds)
+			table.rows = append(table.rows,
+				TableRow{fmt.Sprintf("Bal %d", step.iteration), balance, true},
+				TableRow{fmt.Sprintf("CO %d", step.iteration), carryover, true})
+		}
+		for e := range step.balances {
+			i := layout.offsets[step.joint] + e
+			balance[i] += step.balances[e]
+			carryover[layout.farEnd[i]] += step.carryovers[e]
+		}
+	}
+	table.rows = append(table.rows, TableRow{"Sum", layout.moment, false})
+	return table
+}
+
+// jointOf finds the joint that owns flat end i
+func jointOf(layout *Layout, i int) int {
+	low, high := 0, layout.numJoints()-1
+	for low < high {
+		mid := (low + high + 1) / 2
+		if layout.offsets[mid] <= i {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+	return low
+}
+
+func (row *TableRow) cell(i int) string {
+	if row.isStep && row.values[i] == 0 {
+		return ""
+	}
+	if row.label == "DF" {
+		return strconv.FormatFloat(row.values[i], 'f', 3, 64)
+	}
+	return strconv.FormatFloat(row.values[i], 'f', 2, 64)
+}
+
+// write renders the table as "text", "csv" or "html"
+func (table *HardyCrossTable) write(w io.Writer, format string) error {
+	out := bufio.NewWriter(w)
+	switch format {
+	case "text":
+		fmt.Fprintf(out, "%-8s", "Joint")
+		for _, joint := range table.joints {
+			fmt.Fprintf(out, "%12s", joint)
+		}
+		fmt.Fprintf(out, "\n%-8s", "End")
+		for _, end := range table.ends {
+			fmt.Fprintf(out, "%12s", end)
+		}
+		fmt.Fprintln(out)
+		for _, row := range table.rows {
+			fmt.Fprintf(out, "%-8s", row.label)
+			for i := range row.values {
+				fmt.Fprintf(out, "%12s", row.cell(i))
+			}
+			fmt.Fprintln(out)
+		}
+	case "csv":
+		writer := csv.NewWriter(out)
+		writer.Write(append([]string{"Joint"}, table.joints...))
+		writer.Write(append([]string{"End"}, table.ends...))
+		for _, row := range table.rows {
+			record := []string{row.label}
+			for i := range row.values {
+				record = append(record, row.cell(i))
+			}
+			writer.Write(record)
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return err
+		}
+	case "html":
+		fmt.Fprintln(out, `<table border="1" cellspacing="0" cellpadding="3" style="font-family: monospace; text-align: right">`)
+		fmt.Fprint(out, "<tr><th>Joint</th>")
+		for _, joint := range table.joints {
+			fmt.Fprintf(out, "<th>%s</th>", html.EscapeString(joint))
+		}
+		fmt.Fprint(out, "</tr>\n<tr><th>End</th>")
+		for _, end := range table.ends {
+			fmt.Fprintf(out, "<th>%s</th>", html.EscapeString(end))
+		}
+		fmt.Fprintln(out, "</tr>")
+		for _, row := range table.rows {
+			fmt.Fprintf(out, "<tr><th>%s</th>", html.EscapeString(row.label))
+			for i := range row.values {
+				fmt.Fprintf(out, "<td>%s</td>", row.cell(i))
+			}
+			fmt.Fprintln(out, "</tr>")
+		}
+		fmt.Fprintln(out, "</table>")
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+	return out.Flush()
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+//*******************VALIDATION********************
+
+// Severity of a validation finding, a model with an error is not solved
+type Severity int
+
+const (
+	SEVERITY_WARNING Severity = iota
+	SEVERITY_ERROR
+)
+
+var severityNames = map[Severity]string{
+	SEVERITY_WARNING: "warning",
+	SEVERITY_ERROR:   "error",
+}
+
+func (severity Severity) String() string {
+	return severityNames[severity]
+}
+
+// Finding is one problem of a model, check names the test that found it
+type Finding struct {
+	severity Severity
+	check    string
+	message  string
+}
+
+func (finding Finding) String() string {
+	return fmt.Sprintf("%s: %s: %s", finding.severity, finding.check, finding.message)
+}
+
+func countSeverity(findings []Finding, severity Severity) (count int) {
+	for _, finding := range findings {
+		if finding.severity == severity {
+			count++
+		}
+	}
+	return count
+}
+
+// components groups the node IDs joined by members, each group and the groups
+// in ascending order. A node with no members is a group of its own.
+func components(structure *Structure) [][]int {
+	ids := make([]int, 0, len(structure.nodeMap))
+	for id := range structure.nodeMap {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	component := make(map[int]int, len(ids))
+	var groups [][]int
+	for _, id := range ids {
+		if _, ok := component[id]; ok {
+			continue
+		}
+		k := len(groups)
+		component[id] = k
+		group := []int{id}
+		for next := 0; next < len(group); next++ {
+			for _, end := range structure.nodeMap[group[next]].ends {
+				if end.member < 0 {
+					continue
+				}
+				if _, ok := component[end.otherEndNodeID]; !ok {
+					component[end.otherEndNodeID] = k
+					group = append(group, end.otherEndNodeID)
+				}
+			}
+		}
+		sort.Ints(group)
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// isSupported tells whether the support of a node holds it to the ground
+func (node Node) isSupported() bool {
+	return node.support != SUPPORT_JOINT && node.support != SUPPORT_FREE
+}
+
+// validateStructure checks a model as read, before the supports, releases and
+// normalization change its stiffnesses:
+//
+//	isolated nodes         warning, they are left out
+//	zero stiffness         warning, the end takes no moment
+//	negative stiffness     error
+//	zero df sum            error, the joint can never be balanced
+//	duplicate members      warning, they act side by side
+//	self-loops             error
+//	unsupported parts      warning, a group of members with no support is a
+//	                       mechanism, only its joint rotations are solved
+//	fixed to fixed members warning, they keep their fixed end moments
+//	NaN or Inf values      error
+func validateStructure(structure *Structure) (findings []Finding) {
+	add := func(severity Severity, check string, format string, args ...interface{}) {
+		findings = append(findings, Finding{severity, check, fmt.Sprintf(format, args...)})
+	}
+
+	//NaN and Inf parse as numbers, but no solver can balance them
+	ids := make([]int, 0, len(structure.nodeMap))
+	for id := range structure.nodeMap {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		node := structure.nodeMap[id]
+		if !isFinite(node.springStiffness, node.x, node.y, node.z) {
+			add(SEVERITY_ERROR, "finite", "node %d has a spring stiffness or coordinate that is not finite", id)
+		}
+	}
+	for _, member := range structure.members() {
+		if !isFinite(member.end1.df, member.end2.df, member.end1.ei) {
+			add(SEVERITY_ERROR, "finite", "member %d has a stiffness or EI that is not finite", member.id)
+		}
+	}
+	for _, loadCase := range structure.loadCases {
+		for id, moments := range loadCase.moments {
+			if !isFinite(moments[0], moments[1]) {
+				add(SEVERITY_ERROR, "finite", "member %d has fixed end moments %v in case %s", id, moments, loadCase.name)
+			}
+		}
+	}
+	for _, imposed := range structure.imposed {
+		if !isFinite(imposed.settlement, imposed.rotation) {
+			add(SEVERITY_ERROR, "finite", "the settlement or rotation of node %d is not finite", imposed.node)
+		}
+	}
+	for _, misfit := range structure.misfits {
+		if !isFinite(misfit.rotation[:]...) {
+			add(SEVERITY_ERROR, "finite", "the misfit of member %d is not finite", misfit.member)
+		}
+	}
+	for _, combination := range structure.combinations {
+		if !isFinite(combination.factors...) {
+			add(SEVERITY_ERROR, "finite", "combination %s has a factor that is not finite", combination.name)
+		}
+	}
+	if structure.liveLoad != nil && !isFinite(structure.liveLoad.intensity) {
+		add(SEVERITY_ERROR, "finite", "the live load is not finite")
+	}
+	if structure.train != nil && (!isFinite(structure.train.loads...) || !isFinite(structure.train.spacings...)) {
+		add(SEVERITY_ERROR, "finite", "an axle load or spacing of the train is not finite")
+	}
+
+	pairs := make(map[[2]int]int)
+	for _, member := range structure.members() {
+		for side, end := range []*End{member.end1, member.end2} {
+			switch {
+			case end.df < 0:
+				add(SEVERITY_ERROR, "stiffness", "member %d end %d has negative stiffness %g", member.id, side+1, end.df)
+			case end.df == 0:
+				add(SEVERITY_WARNING, "stiffness", "member %d end %d has zero stiffness", member.id, side+1)
+			}
+		}
+		if member.node1 == member.node2 {
+			add(SEVERITY_ERROR, "self-loop", "member %d joins node %d to itself", member.id, member.node1)
+			continue
+		}
+		if structure.nodeMap[member.node1].isFixed && structure.nodeMap[member.node2].isFixed {
+			add(SEVERITY_WARNING, "fixed-fixed", "member %d joins fixed nodes %d and %d, it keeps its fixed end moments", member.id, member.node1, member.node2)
+		}
+
+		pair := [2]int{member.node1, member.node2}
+		if pair[0] > pair[1] {
+			pair[0], pair[1] = pair[1], pair[0]
+		}
+		if first, ok := pairs[pair]; ok {
+			add(SEVERITY_WARNING, "duplicate", "members %d and %d both join nodes %d and %d", first, member.id, pair[0], pair[1])
+		} else {
+			pairs[pair] = member.id
+		}
+	}
+
+	for _, group := range components(structure) {
+		if len(group) == 1 && len(structure.nodeMap[group[0]].ends) == 0 {
+			add(SEVERITY_WARNING, "isolated", "node %d has no members", group[0])
+			continue
+		}
+		isSupported := false
+		for _, id := range group {
+			node := structure.nodeMap[id]
+			isSupported = isSupported || node.isSupported()
+			if node.support == SUPPORT_SPRING && node.springStiffness < 0 {
+				add(SEVERITY_ERROR, "stiffness", "node %d has negative spring stiffness %g", id, node.springStiffness)
+			}
+			if node.isLocked() {
+				continue
+			}
+			dfSum := node.springStiffness
+			for _, end := range node.ends {
+				dfSum += end.df
+			}
+			if dfSum == 0 {
+				add(SEVERITY_ERROR, "df sum", "the stiffnesses at node %d sum to zero", id)
+			}
+		}
+		if !isSupported {
+			add(SEVERITY_WARNING, "mechanism", "nodes %s have no support", nodeList(group))
+		}
+	}
+	return findings
+}
+
+// nodeList shortens a long list of node IDs
+func nodeList(ids []int) string {
+	if len(ids) > 10 {
+		return fmt.Sprintf("%v... (%d nodes)", ids[:10], len(ids))
+	}
+	return fmt.Sprint(ids)
+}
+
+// writeFindings lists the findings, errors first
+func writeFindings(w io.Writer, findings []Finding) error {
+	sort.SliceStable(findings, func(a, b int) bool { return findings[a].severity > findings[b].severity })
+	for _, finding := range findings {
+		fmt.Fprintln(w, finding)
+	}
+	_, err := fmt.Fprintf(w, "%d error(s), %d warning(s)\n",
+		countSeverity(findings, SEVERITY_ERROR), countSeverity(findings, SEVERITY_WARNING))
+	return err
+}
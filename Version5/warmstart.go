@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//*******************WARM START********************
+
+// An edit of a solved structure leaves its end moments in place and only adds
+// the unbalance the edit makes, so that the next solve starts from the
+// converged state and only the joints near the edit have work to do. The
+// solved layout is kept, the ends of the edited nodes are copied into it and
+// the work stealing scheduler starts from those joints alone. The load cases
+// follow the edits of the base case but are not solved.
+
+// renormalize sets the distribution factors of a node from its end stiffnesses
+func renormalize(node Node) {
+	sum := float64(0)
+	for _, end := range node.ends {
+		sum += end.stiffness
+	}
+	for _, end := range node.ends {
+		end.df = 0
+		if sum > 0 {
+			end.df = end.stiffness / sum
+		}
+	}
+}
+
+func (structure *Structure) editedMember(id int) (*Member, error) {
+	if id < 0 || id >= len(structure.memberList) || structure.memberList[id].removed {
+		return nil, fmt.Errorf("no member %d", id)
+	}
+	return structure.memberList[id], nil
+}
+
+// checkMemberCount refuses edits at nodes whose support was applied for the
+// number of members they had, a released pin or roller and a free tip
+func (structure *Structure) checkMemberCount(id int) error {
+	switch node := structure.nodeMap[id]; node.support {
+	case SUPPORT_PINNED, SUPPORT_ROLLER, SUPPORT_FREE:
+		return fmt.Errorf("node %d is %s, adding or removing its members needs a fresh solve", id, node.support)
+	}
+	return nil
+}
+
+// changeMoments adds to the fixed end moments of a member
+func (structure *Structure) changeMoments(id int, delta [2]float64) error {
+	member, err := structure.editedMember(id)
+	if err != nil {
+		return err
+	}
+	structure.loadCases[0].moments[id][0] += delta[0]
+	structure.loadCases[0].moments[id][1] += delta[1]
+
+	delta = structure.releasedMoments(member, delta)
+	for side, end := range []*End{member.end1, member.end2} {
+		end.moment += delta[side]
+		end.fem += delta[side]
+	}
+	return nil
+}
+
+// scaleStiffness multiplies the stiffness of a member by factor. The part of
+// an end moment that comes from the joint rotations is proportional to the
+// stiffness, so it is scaled with it and the joints take the difference as
+// unbalance.
+func (structure *Structure) scaleStiffness(id int, factor float64) error {
+	member, err := structure.editedMember(id)
+	if err != nil {
+		return err
+	}
+	if factor <= 0 {
+		return fmt.Errorf("stiffness factor %v of member %d must be positive", factor, id)
+	}
+	for _, end := range []*End{member.end1, member.end2} {
+		end.moment = end.fem + factor*(end.moment-end.fem)
+		end.stiffness *= factor
+	}
+	renormalize(structure.nodeMap[member.node1])
+	renormalize(structure.nodeMap[member.node2])
+	return nil
+}
+
+// jointRotation recovers the rotation of a node from the converged moments,
+// in units where an end of stiffness k turning by theta takes k theta. For an
+// end e whose far end f carries back f.cof, the rotation parts are
+// r_e = k_e theta + f.cof k_f theta_far and r_f = k_f theta_far + e.cof k_e theta.
+func (structure *Structure) jointRotation(id int) float64 {
+	node := structure.nodeMap[id]
+	if node.isLocked() {
+		return 0
+	}
+	var best *End
+	for _, end := range node.ends {
+		if end.stiffness > 0 && (best == nil || end.stiffness > best.stiffness) {
+			best = end
+		}
+	}
+	if best == nil {
+		return 0
+	}
+	if best.member < 0 {
+		//grounded spring
+		return best.moment / best.stiffness
+	}
+
+	farNode := structure.nodeMap[best.otherEndNodeID]
+	far := farNode.ends[best.otherEndIndex]
+	near := best.moment - best.fem
+	if farNode.isLocked() || far.cof == 0 {
+		return near / best.stiffness
+	}
+	return (near - far.cof*(far.moment-far.fem)) / (best.stiffness * (1 - far.cof*best.cof))
+}
+
+// addMember joins two nodes with a new member of stiffness k and fixed end
+// moments fem. Its ends take the moments of the current joint rotations, the
+// rest of the structure sees them as unbalance.
+func (structure *Structure) addMember(id1, id2 int, k float64, fem [2]float64) (*Member, error) {
+	_, ok1 := structure.nodeMap[id1]
+	_, ok2 := structure.nodeMap[id2]
+	if !ok1 || !ok2 || id1 == id2 || k <= 0 {
+		return nil, fmt.Errorf("cannot add a member %d-%d of stiffness %v", id1, id2, k)
+	}
+	for _, id := range []int{id1, id2} {
+		if err := structure.checkMemberCount(id); err != nil {
+			return nil, err
+		}
+	}
+	theta1 := structure.jointRotation(id1)
+	theta2 := structure.jointRotation(id2)
+
+	end1, end2 := connectNodes(structure, id1, k, fem[0], id2, k, fem[1])
+	member := structure.memberList[len(structure.memberList)-1]
+	adjustForFarSupport(end1, end2, structure.nodeMap[id2])
+	adjustForFarSupport(end2, end1, structure.nodeMap[id1])
+	end1.stiffness, end2.stiffness = end1.df, end2.df
+	end1.fem, end2.fem = fem[0], fem[1]
+	end1.moment = fem[0] + end1.stiffness*theta1 + end2.cof*end2.stiffness*theta2
+	end2.moment = fem[1] + end2.stiffness*theta2 + end1.cof*end1.stiffness*theta1
+
+	for _, loadCase := range structure.loadCases {
+		loadCase.moments = append(loadCase.moments, [2]float64{})
+	}
+	structure.loadCases[0].moments[member.id] = fem
+	renormalize(structure.nodeMap[id1])
+	renormalize(structure.nodeMap[id2])
+	return member, nil
+}
+
+// removeMember takes a member out, the moments it carried become unbalance at
+// its nodes. Its ends stay with no stiffness so that end indices hold.
+func (structure *Structure) removeMember(id int) error {
+	member, err := structure.editedMember(id)
+	if err != nil {
+		return err
+	}
+	for _, nodeID := range []int{member.node1, member.node2} {
+		if err := structure.checkMemberCount(nodeID); err != nil {
+			return err
+		}
+		node := structure.nodeMap[nodeID]
+		remaining := float64(0)
+		for _, end := range node.ends {
+			if end != member.end1 && end != member.end2 {
+				remaining += end.stiffness
+			}
+		}
+		if remaining == 0 && !node.isLocked() {
+			return fmt.Errorf("removing member %d leaves node %d with no stiffness", id, nodeID)
+		}
+	}
+
+	for _, end := range []*End{member.end1, member.end2} {
+		end.moment, end.fem, end.stiffness, end.cof = 0, 0, 0, 0
+	}
+	member.removed = true
+	for _, loadCase := range structure.loadCases {
+		loadCase.moments[id] = [2]float64{}
+	}
+	renormalize(structure.nodeMap[member.node1])
+	renormalize(structure.nodeMap[member.node2])
+	return nil
+}
+
+// applyEdits reads an edit file, one edit per line:
+//
+//	FEM <member> <dm1> <dm2>           add to the fixed end moments
+//	STIFFNESS <member> <factor>        scale the stiffness
+//	ADD <node1> <node2> <k> <m1> <m2>  new member with fixed end moments
+//	REMOVE <member>
+//
+// and returns the nodes whose ends the edits changed
+func applyEdits(structure *Structure, filename string) (touched []int, err error) {
+	editFile, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer editFile.Close()
+
+	scanner := bufio.NewScanner(editFile)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		values := make([]float64, len(fields)-1)
+		for k, field := range fields[1:] {
+			if values[k], err = strconv.ParseFloat(field, 64); err != nil {
+				return nil, fmt.Errorf("%s line %d: %v", filename, line, err)
+			}
+		}
+		id := func(k int) int {
+			if k >= len(values) || values[k] != math.Trunc(values[k]) {
+				return -1
+			}
+			return int(values[k])
+		}
+
+		switch {
+		case fields[0] == "FEM" && len(values) == 3:
+			err = structure.changeMoments(id(0), [2]float64{values[1], values[2]})
+		case fields[0] == "STIFFNESS" && len(values) == 2:
+			err = structure.scaleStiffness(id(0), values[1])
+		case fields[0] == "ADD" && len(values) == 5:
+			_, err = structure.addMember(id(0), id(1), values[2], [2]float64{values[3], values[4]})
+		case fields[0] == "REMOVE" && len(values) == 1:
+			err = structure.removeMember(id(0))
+		default:
+			err = fmt.Errorf("unknown edit %v", fields)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", filename, line, err)
+		}
+		if fields[0] == "ADD" {
+			touched = append(touched, id(0), id(1))
+		} else {
+			member := structure.memberList[id(0)]
+			touched = append(touched, member.node1, member.node2)
+		}
+	}
+	return touched, scanner.Err()
+}
+
+// refresh copies the ends of the touched nodes from the structure into the
+// layout and returns their joints, ok is false when a node has gained ends
+// the layout has no room for
+func (layout *Layout) refresh(structure *Structure, touched []int) (joints []int, ok bool) {
+	for _, id := range touched {
+		j := sort.SearchInts(layout.nodeIDs, id)
+		node := structure.nodeMap[id]
+		if j == layout.numJoints() || layout.nodeIDs[j] != id || layout.offsets[j+1]-layout.offsets[j] != len(node.ends) {
+			return nil, false
+		}
+		for endIndex, end := range node.ends {
+			i := layout.offsets[j] + endIndex
+			layout.df[i], layout.cof[i], layout.moment[i] = end.df, end.cof, end.moment
+		}
+		joints = append(joints, j)
+	}
+	return joints, true
+}
+
+// analyseLayoutEdited solves the edits of a solved layout in id order that was
+// written back to structure before them, starting from the touched joints. A
+// layout that cannot take an added member is replaced.
+func analyseLayoutEdited(layout *Layout, structure *Structure, touched []int) (*Layout, error) {
+	joints, ok := layout.refresh(structure, touched)
+	if !ok {
+		layout = newLayout(structure)
+		joints, _ = layout.refresh(structure, touched)
+	}
+	jointSets := make([][]int, 4)
+	jointSets[0] = joints
+	return layout, runScheduler(newScheduler(layout, jointSets))
+}
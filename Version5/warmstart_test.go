@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestEditedSolveBalancesEveryJoint(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	filename := writeGrid(t, 100)
+	edits := filepath.Join(t.TempDir(), "edits.txt")
+	if err := os.WriteFile(edits, []byte("FEM 9900 -50 50\nSTIFFNESS 5000 3\nREMOVE 200\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for run := 0; run < 20; run++ {
+		structure, findings := readStructureFile(filename)
+		if structure == nil {
+			t.Fatal(findings)
+		}
+		layout := newLayout(structure)
+		if _, err := analyseLayoutSequential(layout, nil); err != nil {
+			t.Fatal(err)
+		}
+		layout.writeBack()
+
+		touched, err := applyEdits(structure, edits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if layout, err = analyseLayoutEdited(layout, structure, touched); err != nil {
+			t.Fatal(err)
+		}
+		if largest := largestUnbalance(layout, layout.moment); largest > TOLERANCE {
+			t.Fatalf("run %d ends with unbalance %g", run, largest)
+		}
+	}
+}